@@ -0,0 +1,27 @@
+package certs
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// RedirectHandler redirects every plain HTTP request to the same host and
+// path on the HTTPS port. Port 443 is left out of the target URL.
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		// 308 keeps the method and body, so speed test uploads are not
+		// turned into GETs.
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
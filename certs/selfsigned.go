@@ -0,0 +1,192 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 397 * 24 * time.Hour // the longest lifetime browsers accept
+	// leafRenewBefore is how close to expiry a leaf is replaced on startup.
+	leafRenewBefore = 30 * 24 * time.Hour
+)
+
+// SelfSigned lists the files written by EnsureSelfSigned.
+type SelfSigned struct {
+	// CAFile is the local CA certificate. Installing it as a trusted root on
+	// the test devices makes browsers accept the node's certificate.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// EnsureSelfSigned makes sure dir holds a local CA and a leaf certificate
+// signed by it that covers every name and IP address in hosts. Existing
+// files are reused, so devices that trust the CA keep doing so; the leaf is
+// reissued when it is missing, close to expiry or does not cover hosts.
+func EnsureSelfSigned(dir string, hosts []string) (SelfSigned, error) {
+	files := SelfSigned{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return files, err
+	}
+
+	ca, caKey, err := loadPair(files.CAFile, caKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		ca, caKey, err = newCA()
+		if err == nil {
+			err = writePair(files.CAFile, caKeyFile, ca, caKey)
+		}
+	}
+	if err != nil {
+		return files, fmt.Errorf("local CA: %w", err)
+	}
+
+	leaf, _, err := loadPair(files.CertFile, files.KeyFile)
+	if err == nil && leafValid(leaf, ca, hosts) {
+		return files, nil
+	}
+	leaf, leafKey, err := newLeaf(ca, caKey, hosts)
+	if err == nil {
+		err = writePair(files.CertFile, files.KeyFile, leaf, leafKey)
+	}
+	if err != nil {
+		return files, fmt.Errorf("leaf certificate: %w", err)
+	}
+	return files, nil
+}
+
+// leafValid reports whether leaf was issued by ca, is not about to expire
+// and covers every host.
+func leafValid(leaf, ca *x509.Certificate, hosts []string) bool {
+	if leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	if time.Until(leaf.NotAfter) < leafRenewBefore {
+		return false
+	}
+	for _, host := range hosts {
+		if host != "" && leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"pltester"},
+			CommonName:   "pltester local CA " + hostname,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	return issue(template, nil, nil)
+}
+
+func newLeaf(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"pltester"},
+			CommonName:   "pltester node",
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return issue(template, ca, caKey)
+}
+
+// issue creates a key and a certificate from template, signed by parent or
+// self-signed when parent is nil.
+func issue(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func loadPair(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s or %s is not PEM encoded", certFile, keyFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writePair(certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// Write the key first so a reloader never pairs a new certificate with
+	// the old key for longer than one check.
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pltester/datachannel"
+)
+
+// echoBenchResult is the outcome of an in-process echo benchmark.
+type echoBenchResult struct {
+	Channels      int     `json:"channels"`
+	Size          int     `json:"size"`
+	Procs         int     `json:"procs"`
+	Duration      float64 `json:"duration"` // seconds
+	Echoed        uint64  `json:"echoed"`
+	PerSecond     float64 `json:"perSecond"`
+	PerSecondCore float64 `json:"perSecondPerCore"`
+	Errors        uint64  `json:"errors"`
+}
+
+// runEchoBench measures how many messages per second the server's DataChannel
+// echo path sustains. Clients and servers run in this process over loopback,
+// so the per-core figure also pays for the client side and is a lower bound.
+func runEchoBench(args []string) error {
+	fs := flag.NewFlagSet("echobench", flag.ExitOnError)
+	duration := fs.Duration("duration", 5*time.Second, "how long to send")
+	channels := fs.Int("channels", 1, "number of concurrent connections")
+	size := fs.Int("size", 12, "message size in bytes, at least 12")
+	window := fs.Int("window", 256, "unanswered messages allowed per connection")
+	procs := fs.Int("procs", 1, "GOMAXPROCS for the benchmark")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pltcli echobench [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *channels < 1 || *window < 1 || *procs < 1 || *size < 12 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	runtime.GOMAXPROCS(*procs)
+	// Peer setup logs every connection; keep the output to the result.
+	log.SetOutput(io.Discard)
+
+	var stats datachannel.EchoStats
+	var echoed atomic.Uint64
+	clients := make([]*datachannel.LoopbackEcho, 0, *channels)
+	for i := 0; i < *channels; i++ {
+		// At most window messages stay unanswered, so the benchmark measures
+		// the echo rate rather than queue growth.
+		client, err := datachannel.NewLoopbackEcho(&stats, *window, func() { echoed.Add(1) })
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	start := time.Now()
+	deadline := start.Add(*duration)
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(l *datachannel.LoopbackEcho) {
+			defer wg.Done()
+			runEchoBenchClient(l, deadline, *size)
+		}(client)
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	result := echoBenchResult{
+		Channels:  *channels,
+		Size:      *size,
+		Procs:     *procs,
+		Duration:  elapsed,
+		Echoed:    echoed.Load(),
+		PerSecond: float64(echoed.Load()) / elapsed,
+		Errors:    stats.Snapshot().Errors,
+	}
+	result.PerSecondCore = result.PerSecond / float64(*procs)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	fmt.Printf("echo benchmark: %d connection(s), %d-byte messages, GOMAXPROCS=%d\n", result.Channels, result.Size, result.Procs)
+	fmt.Printf("  %d messages echoed in %.1fs: %.0f msg/s, %.0f msg/s per core\n",
+		result.Echoed, result.Duration, result.PerSecond, result.PerSecondCore)
+	if result.Errors > 0 {
+		fmt.Printf("  %d echo errors\n", result.Errors)
+	}
+	return nil
+}
+
+// runEchoBenchClient sends size-byte messages over one loopback connection
+// until the deadline.
+func runEchoBenchClient(l *datachannel.LoopbackEcho, deadline time.Time, size int) {
+	msg := make([]byte, size)
+	for seq := uint32(0); time.Now().Before(deadline); seq++ {
+		binary.BigEndian.PutUint32(msg, seq)
+		if err := l.Send(msg); err != nil {
+			return
+		}
+	}
+}
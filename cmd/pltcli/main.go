@@ -0,0 +1,48 @@
+// Command pltcli runs pltester measurements from the command line, for hosts
+// that cannot run the browser test.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"stamp", "measure against a STAMP / TWAMP-Light reflector", runSTAMP},
+	{"nat", "discover NAT mapping and filtering behaviour (RFC 5780)", runNAT},
+	{"echobench", "benchmark the DataChannel echo path in-process", runEchoBench},
+	{"probe", "run the loss and latency test against a node as a thin WebRTC client", runProbe},
+	{"quic", "run the loss and latency test over QUIC datagrams", runQUIC},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "pltcli %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pltcli <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+}
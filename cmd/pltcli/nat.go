@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"pltester/nat"
+)
+
+func runNAT(args []string) error {
+	fs := flag.NewFlagSet("nat", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pltcli nat [flags] host[:port]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	target := fs.Arg(0)
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, strconv.Itoa(nat.DefaultPort))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := nat.Discover(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Printf("NAT type: %s\n", report.Type)
+	fmt.Printf("  mapping:   %s\n", report.Mapping)
+	fmt.Printf("  filtering: %s\n", report.Filtering)
+	fmt.Printf("  mapped:    %s\n", strings.Join(report.MappedAddresses, ", "))
+	for _, note := range report.Notes {
+		fmt.Printf("  note: %s\n", note)
+	}
+	if report.NeedsTURN {
+		fmt.Println("Symmetric NAT: peers that are also behind a NAT can only reach this host through a TURN relay.")
+	}
+	return nil
+}
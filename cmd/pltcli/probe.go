@@ -0,0 +1,369 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"pltester/datachannel"
+	"pltester/metrics"
+
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	probeFrameSize     = 12 // uint32 sequence number + float64 send time, as the browser sends
+	probeFlushInterval = 500 * time.Millisecond
+	probeOpenTimeout   = 15 * time.Second
+	probeResultTimeout = 5 * time.Second
+)
+
+// probeResult is what the probe command prints with -json.
+type probeResult struct {
+	Session string            `json:"session"`
+	Path    *datachannel.Path `json:"path,omitempty"`
+	Report  metrics.Report    `json:"report"`
+}
+
+// runProbe runs the browser's loss and latency test as a thin client: the
+// node creates the probe channel and sends the offer, so this side only
+// answers. Samples go back over signalling and the node computes the
+// metrics, so the run is stored as a session like a browser test.
+func runProbe(args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	rate := fs.Int("rate", 50, "probe packets per second")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send")
+	size := fs.Int("size", probeFrameSize, "probe size in bytes, at least 12")
+	timeout := fs.Duration("timeout", 3*time.Second, "time after which a probe counts as lost")
+	transport := fs.String("transport", "", "transport policy: udp, tcp or relay")
+	network := fs.String("network", "", "address family: ipv4 or ipv6")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	caCert := fs.String("cacert", "", "PEM file of the CA that signed the node's certificate, e.g. its self-signed etc/tls/ca.pem")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pltcli probe [flags] ws://host:port/ws")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *rate < 1 || *size < probeFrameSize {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	signalURL, err := probeSignalURL(fs.Arg(0), *transport, *network)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := probeTLSConfig(*caCert)
+	if err != nil {
+		return err
+	}
+	c, err := dialProbe(signalURL, tlsConfig, *network, *transport == datachannel.TransportRelay)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	select {
+	case <-c.opened:
+	case err := <-c.failed:
+		return err
+	case <-time.After(probeOpenTimeout):
+		return errors.New("DataChannel did not open; the transport may be blocked on this network")
+	}
+	if !*asJSON {
+		fmt.Printf("session %s\n", c.sessionID())
+		if path := c.selectedPath(); path != nil {
+			fmt.Printf("connected over %s: %s -> %s (local %s, node %s)\n",
+				path.Protocol, path.Remote, path.Local, path.RemoteType, path.LocalType)
+		}
+	}
+
+	report, err := c.run(*rate, *size, *duration, *timeout)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(probeResult{Session: c.sessionID(), Path: c.selectedPath(), Report: report})
+	}
+	fmt.Printf("probe %s: %d sent, %d received, %.2f%% loss\n",
+		signalURL, report.Sent, report.Received, report.LossRatio*100)
+	printReport("round trip", report)
+	return nil
+}
+
+// probeSignalURL adds the /ws path and the session restrictions to the
+// node's address.
+func probeSignalURL(raw, transport, network string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported node URL %q", raw)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/ws"
+	}
+	q := u.Query()
+	if transport != "" {
+		q.Set("transport", transport)
+	}
+	if network != "" {
+		q.Set("network", network)
+	}
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// httpURL is the node's HTTP origin for the signalling URL.
+func httpURL(u *url.URL, path string) string {
+	h := url.URL{Scheme: "http", Host: u.Host, Path: path}
+	if u.Scheme == "wss" {
+		h.Scheme = "https"
+	}
+	return h.String()
+}
+
+// probeTLSConfig trusts the CA in caFile in addition to the system roots;
+// without a file the system roots alone are used.
+func probeTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// fetchICEServers asks the node which ICE servers to use, falling back to
+// public STUN for nodes without /api/ice.
+func fetchICEServers(u *url.URL, tlsConfig *tls.Config) []webrtc.ICEServer {
+	fallback := []webrtc.ICEServer{{URLs: datachannel.PublicSTUNServers}}
+	client := http.Client{Timeout: 5 * time.Second}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// The transport adds its ALPN protocols to the config it is given;
+		// the WebSocket dial must keep offering none to stay on HTTP/1.1.
+		transport.TLSClientConfig = tlsConfig.Clone()
+		client.Transport = transport
+	}
+	resp, err := client.Get(httpURL(u, "/api/ice"))
+	if err != nil {
+		return fallback
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fallback
+	}
+	var body struct {
+		ICEServers []webrtc.ICEServer `json:"iceServers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || len(body.ICEServers) == 0 {
+		return fallback
+	}
+	return body.ICEServers
+}
+
+type probeClient struct {
+	*sampler
+	ws     *websocket.Conn
+	pc     *webrtc.PeerConnection
+	opened chan struct{}
+
+	mu      sync.Mutex
+	session string
+	path    *datachannel.Path
+}
+
+func dialProbe(u *url.URL, tlsConfig *tls.Config, network string, relay bool) (*probeClient, error) {
+	config := webrtc.Configuration{ICEServers: fetchICEServers(u, tlsConfig)}
+	if relay {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	// pion leaves TCP out by default; without it the client cannot open
+	// active TCP connections to the node's passive ICE-TCP candidates.
+	networkTypes, err := datachannel.NetworkTypesFor(network)
+	if err != nil {
+		return nil, err
+	}
+	if networkTypes == nil {
+		networkTypes = []webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4,
+			webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4,
+			webrtc.NetworkTypeTCP6,
+		}
+	}
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetNetworkTypes(networkTypes)
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine)).NewPeerConnection(config)
+	if err != nil {
+		return nil, err
+	}
+	wsConfig, err := websocket.NewConfig(u.String(), httpURL(u, "/"))
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	wsConfig.TlsConfig = tlsConfig
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	c := &probeClient{
+		ws:     ws,
+		pc:     pc,
+		opened: make(chan struct{}),
+	}
+	c.sampler = newSampler(c.send)
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			c.send(candidate.ToJSON())
+		}
+	})
+	pc.OnDataChannel(func(d *webrtc.DataChannel) {
+		if d.Label() != datachannel.ProbeChannelLabel {
+			return
+		}
+		d.OnOpen(func() {
+			c.sendFrame = d.Send
+			close(c.opened)
+		})
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			c.received(msg.Data)
+		})
+	})
+
+	go c.readSignals()
+	if err := c.send(struct {
+		Type string `json:"type"`
+	}{"serveroffer"}); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *probeClient) close() {
+	c.pc.Close()
+	c.ws.Close()
+}
+
+func (c *probeClient) send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return websocket.Message.Send(c.ws, string(data))
+}
+
+func (c *probeClient) sessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+func (c *probeClient) selectedPath() *datachannel.Path {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.path
+}
+
+// readSignals answers the node's offer and collects its candidates and
+// results until the signalling connection closes.
+func (c *probeClient) readSignals() {
+	for {
+		var raw string
+		if err := websocket.Message.Receive(c.ws, &raw); err != nil {
+			c.fail(fmt.Errorf("signalling closed: %w", err))
+			close(c.reports)
+			return
+		}
+		var msg struct {
+			Type      string            `json:"type"`
+			ID        string            `json:"id"`
+			SDP       string            `json:"sdp"`
+			Candidate string            `json:"candidate"`
+			State     string            `json:"state"`
+			Path      *datachannel.Path `json:"path"`
+			Report    metrics.Report    `json:"report"`
+			Reason    string            `json:"reason"`
+			Request   string            `json:"request"`
+			Error     string            `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			continue
+		}
+		switch {
+		case msg.Type == "session":
+			c.mu.Lock()
+			c.session = msg.ID
+			c.mu.Unlock()
+		case msg.Type == "offer":
+			if err := c.answer(msg.SDP); err != nil {
+				c.fail(err)
+			}
+		case msg.Type == "transport":
+			if msg.State == "failed" {
+				c.fail(errors.New("ICE failed: no candidate pair could connect"))
+			}
+			c.mu.Lock()
+			c.path = msg.Path
+			c.mu.Unlock()
+		case msg.Type == "metrics":
+			c.reports <- msg.Report
+		case msg.Type == "closed":
+			c.fail(fmt.Errorf("node closed the session: %s", msg.Reason))
+		case msg.Type == "error":
+			c.fail(fmt.Errorf("node rejected the %s request: %s", msg.Request, msg.Error))
+		case msg.Candidate != "":
+			var candidate webrtc.ICECandidateInit
+			if err := json.Unmarshal([]byte(raw), &candidate); err == nil {
+				c.pc.AddICECandidate(candidate)
+			}
+		}
+	}
+}
+
+func (c *probeClient) answer(sdp string) error {
+	if err := c.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+		return err
+	}
+	answer, err := c.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	return c.send(answer)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"pltester/metrics"
+	"pltester/ws"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicResult is what the quic command prints with -json.
+type quicResult struct {
+	Session string         `json:"session"`
+	Remote  string         `json:"remote"`
+	Report  metrics.Report `json:"report"`
+}
+
+// runQUIC runs the loss and latency test over unreliable QUIC DATAGRAM
+// frames. It needs no WebRTC negotiation, only UDP to the node's HTTP/3
+// port, which makes it a light second UDP measurement for scripts. The run
+// is stored as a session like a browser test.
+func runQUIC(args []string) error {
+	fs := flag.NewFlagSet("quic", flag.ExitOnError)
+	rate := fs.Int("rate", 50, "probe packets per second")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send")
+	size := fs.Int("size", probeFrameSize, "probe size in bytes, at least 12 and at most one datagram (about 1200)")
+	timeout := fs.Duration("timeout", 3*time.Second, "time after which a probe counts as lost")
+	caCert := fs.String("cacert", "", "PEM file of the CA that signed the node's certificate, e.g. its self-signed etc/tls/ca.pem")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pltcli quic [flags] host:port")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *rate < 1 || *size < probeFrameSize {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	addr, err := quicAddr(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := probeTLSConfig(*caCert)
+	if err != nil {
+		return err
+	}
+	c, err := dialQUIC(addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	select {
+	case <-c.opened:
+	case err := <-c.failed:
+		return err
+	case <-time.After(probeOpenTimeout):
+		return errors.New("the node did not open the control stream")
+	}
+	remote := c.conn.RemoteAddr().String()
+	if !*asJSON {
+		fmt.Printf("session %s\n", c.sessionID())
+		fmt.Printf("connected over QUIC to %s\n", remote)
+	}
+
+	report, err := c.run(*rate, *size, *duration, *timeout)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(quicResult{Session: c.sessionID(), Remote: remote, Report: report})
+	}
+	fmt.Printf("quic %s: %d sent, %d received, %.2f%% loss\n",
+		addr, report.Sent, report.Received, report.LossRatio*100)
+	printReport("round trip", report)
+	return nil
+}
+
+// quicAddr accepts host:port or the node's https:// URL.
+func quicAddr(raw string) (string, error) {
+	if !strings.Contains(raw, "://") {
+		return raw, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported node URL %q", raw)
+	}
+	if u.Port() == "" {
+		return u.Hostname() + ":443", nil
+	}
+	return u.Host, nil
+}
+
+type quicClient struct {
+	*sampler
+	conn   quic.Connection
+	stream quic.Stream
+	opened chan struct{}
+
+	mu      sync.Mutex // guards session and writes to stream
+	session string
+}
+
+func dialQUIC(addr string, tlsConfig *tls.Config) (*quicClient, error) {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.NextProtos = []string{ws.QUICProbeALPN}
+	ctx, cancel := context.WithTimeout(context.Background(), probeOpenTimeout)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, tlsConfig, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		return nil, err
+	}
+	if !conn.ConnectionState().SupportsDatagrams {
+		conn.CloseWithError(0, "")
+		return nil, errors.New("the node does not support QUIC datagrams")
+	}
+	// The node opens the control stream and sends the session ID on it.
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("control stream: %w", err)
+	}
+	c := &quicClient{
+		conn:   conn,
+		stream: stream,
+		opened: make(chan struct{}),
+	}
+	c.sampler = newSampler(c.send)
+	c.sendFrame = conn.SendDatagram
+
+	go c.readControl()
+	go c.readDatagrams()
+	return c, nil
+}
+
+func (c *quicClient) close() {
+	c.conn.CloseWithError(0, "")
+}
+
+func (c *quicClient) send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.stream.Write(append(data, '\n'))
+	return err
+}
+
+func (c *quicClient) sessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// readControl collects the node's messages until the control stream closes.
+func (c *quicClient) readControl() {
+	scanner := bufio.NewScanner(c.stream)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	for scanner.Scan() {
+		var msg struct {
+			Type   string         `json:"type"`
+			ID     string         `json:"id"`
+			Report metrics.Report `json:"report"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "session":
+			c.mu.Lock()
+			c.session = msg.ID
+			c.mu.Unlock()
+			close(c.opened)
+		case "metrics":
+			c.reports <- msg.Report
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("closed by node")
+	}
+	c.fail(fmt.Errorf("control stream closed: %w", err))
+	close(c.reports)
+}
+
+func (c *quicClient) readDatagrams() {
+	for {
+		data, err := c.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		c.received(data)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"pltester/metrics"
+)
+
+// sampler runs the sequenced probe test over any transport: it sends
+// numbered probes, matches their echoes and reports settled samples to the
+// node, which computes the metrics and answers each batch with a report.
+type sampler struct {
+	epoch   time.Time
+	failed  chan error
+	reports chan metrics.Report
+	// sendFrame sends one probe; it is set once the probe path is open.
+	sendFrame func([]byte) error
+	// sendControl sends a JSON control message to the node.
+	sendControl func(v interface{}) error
+
+	mu      sync.Mutex
+	samples []metrics.Sample // indexed by sequence number
+	cursor  int              // next sample to report
+}
+
+func newSampler(sendControl func(v interface{}) error) *sampler {
+	return &sampler{
+		epoch:       time.Now(),
+		failed:      make(chan error, 1),
+		reports:     make(chan metrics.Report, 16),
+		sendControl: sendControl,
+	}
+}
+
+func (s *sampler) fail(err error) {
+	select {
+	case s.failed <- err:
+	default:
+	}
+}
+
+// now is the time since the client started in milliseconds, like the
+// browser's performance.now().
+func (s *sampler) now() float64 {
+	return float64(time.Since(s.epoch)) / float64(time.Millisecond)
+}
+
+func (s *sampler) received(data []byte) {
+	at := s.now()
+	if len(data) < probeFrameSize {
+		return
+	}
+	seq := int(binary.BigEndian.Uint32(data))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq < len(s.samples) && s.samples[seq].Received == 0 {
+		s.samples[seq].Received = at
+	}
+}
+
+// run sends probes for duration, then waits up to timeout for the last
+// echoes and returns the node's final report.
+func (s *sampler) run(rate, size int, duration, timeout time.Duration) (metrics.Report, error) {
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	flush := time.NewTicker(probeFlushInterval)
+	defer flush.Stop()
+	end := time.After(duration)
+	frame := make([]byte, size)
+	outstanding := 0
+
+sending:
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sendProbe(frame); err != nil {
+				return metrics.Report{}, err
+			}
+		case <-flush.C:
+			if s.flush(float64(timeout/time.Millisecond), false) {
+				outstanding++
+			}
+		case _, ok := <-s.reports:
+			if !ok {
+				return metrics.Report{}, errors.New("control channel closed during the test")
+			}
+			outstanding--
+		case err := <-s.failed:
+			return metrics.Report{}, err
+		case <-end:
+			break sending
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && !s.allReceived() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.flush(0, true)
+	outstanding++
+
+	// The node answers every batch; the answer to the final one covers the
+	// whole run.
+	var report metrics.Report
+	wait := time.After(probeResultTimeout)
+	for outstanding > 0 {
+		select {
+		case r, ok := <-s.reports:
+			if !ok {
+				return report, errors.New("control channel closed before the final result")
+			}
+			report = r
+			outstanding--
+		case <-wait:
+			return report, errors.New("timed out waiting for the final result")
+		}
+	}
+	return report, nil
+}
+
+func (s *sampler) sendProbe(frame []byte) error {
+	s.mu.Lock()
+	seq := len(s.samples)
+	sent := s.now()
+	s.samples = append(s.samples, metrics.Sample{Seq: uint64(seq), Sent: sent})
+	s.mu.Unlock()
+
+	binary.BigEndian.PutUint32(frame, uint32(seq))
+	binary.BigEndian.PutUint64(frame[4:], math.Float64bits(sent))
+	return s.sendFrame(frame)
+}
+
+func (s *sampler) allReceived() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sample := range s.samples[s.cursor:] {
+		if sample.Received == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// flush reports the samples that are settled: answered, older than
+// timeoutMs, or all of them when final. It reports whether a batch was sent.
+func (s *sampler) flush(timeoutMs float64, final bool) bool {
+	cutoff := s.now() - timeoutMs
+	s.mu.Lock()
+	var batch []metrics.Sample
+	for s.cursor < len(s.samples) {
+		sample := s.samples[s.cursor]
+		if sample.Received == 0 && !final && sample.Sent > cutoff {
+			break
+		}
+		if sample.Received == 0 {
+			sample.Lost = true
+		}
+		batch = append(batch, sample)
+		s.cursor++
+	}
+	s.mu.Unlock()
+	if len(batch) == 0 && !final {
+		return false
+	}
+	if batch == nil {
+		batch = []metrics.Sample{}
+	}
+	err := s.sendControl(struct {
+		Type    string           `json:"type"`
+		Samples []metrics.Sample `json:"samples"`
+		Final   bool             `json:"final,omitempty"`
+	}{"samples", batch, final})
+	return err == nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"pltester/metrics"
+	"pltester/stamp"
+)
+
+func runSTAMP(args []string) error {
+	fs := flag.NewFlagSet("stamp", flag.ExitOnError)
+	count := fs.Int("count", 100, "number of test packets")
+	interval := fs.Duration("interval", 20*time.Millisecond, "gap between test packets")
+	size := fs.Int("size", stamp.PacketSize, "test packet size in bytes")
+	timeout := fs.Duration("timeout", 2*time.Second, "time to wait for late replies")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pltcli stamp [flags] host[:port]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	target := fs.Arg(0)
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, strconv.Itoa(stamp.DefaultPort))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := stamp.Run(ctx, target, stamp.Options{
+		Count:    *count,
+		Interval: *interval,
+		Size:     *size,
+		Timeout:  *timeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	fmt.Printf("STAMP %s: %d sent, %d received, %.2f%% loss\n",
+		target, result.RoundTrip.Sent, result.RoundTrip.Received, result.RoundTrip.LossRatio*100)
+	printReport("round trip", result.RoundTrip)
+	printReport("forward", result.Forward)
+	printReport("backward", result.Backward)
+	return nil
+}
+
+func printReport(label string, r metrics.Report) {
+	fmt.Printf("%-10s delay min/avg/p90/max %.3f/%.3f/%.3f/%.3f ms  jitter %.3f ms  ipdv p90 %.3f ms  reordered %d\n",
+		label, r.Latency.Min, r.Latency.Mean, r.Latency.P90, r.Latency.Max, r.Jitter, r.IPDV.P90, r.Reordering.Reordered)
+}
@@ -5,14 +5,49 @@ import (
 	"fmt"
 	"log"
 	"os"
+
+	"pltester/impair"
 )
 
 type Config struct {
 	ListenPort      int    `json:"listen_port"`
-	PublicIP        string `json:"public_ip,omitempty"`         // 公网IP，用于云服务器NAT环境
-	UDPPortMin      uint16 `json:"udp_port_min,omitempty"`      // UDP端口范围最小值 (0表示随机)
-	UDPPortMax      uint16 `json:"udp_port_max,omitempty"`      // UDP端口范围最大值 (0表示随机)
+	PublicIP        string `json:"public_ip,omitempty"`          // 公网IP，用于云服务器NAT环境
+	UDPPortMin      uint16 `json:"udp_port_min,omitempty"`       // UDP端口范围最小值 (0表示随机)
+	UDPPortMax      uint16 `json:"udp_port_max,omitempty"`       // UDP端口范围最大值 (0表示随机)
 	IPAPICustomHost string `json:"ip_api_custom_host,omitempty"` // 可选自建 IP 情报服务地址（替代默认 ip-api.com）
+
+	SessionRetentionMinutes int `json:"session_retention_minutes,omitempty"` // 已结束会话的保留时间 (0表示24小时)
+	TimeSeriesBucketSeconds int `json:"timeseries_bucket_seconds,omitempty"` // 时间序列桶宽度 (0表示1秒)
+	OutageThresholdMs       int `json:"outage_threshold_ms,omitempty"`       // 连续丢包超过该时长记为中断 (0表示200毫秒)
+
+	ResumeGraceSeconds        int `json:"resume_grace_seconds,omitempty"`        // 信令断开后保留会话等待重连的时长 (0表示30秒)
+	NegotiationTimeoutSeconds int `json:"negotiation_timeout_seconds,omitempty"` // 会话建立连接的超时 (0表示30秒)
+	IdleTimeoutSeconds        int `json:"idle_timeout_seconds,omitempty"`        // 没有信令和数据通道流量超过该时长即结束会话 (0表示120秒)
+	ShutdownDrainSeconds      int `json:"shutdown_drain_seconds,omitempty"`      // 关闭时等待进行中测试结束的时长 (0表示30秒)
+
+	SessionArchive string `json:"session_archive,omitempty"` // 关闭时将会话结果追加写入该文件（JSON Lines，留空表示 etc/sessions.jsonl，"-" 表示不保存）
+
+	TLSCertFile      string `json:"tls_cert_file,omitempty"`      // HTTPS 证书文件（PEM），与 tls_key_file 同时配置后 listen_port 改为 HTTPS，文件更新后自动重新加载
+	TLSKeyFile       string `json:"tls_key_file,omitempty"`       // HTTPS 私钥文件（PEM）
+	TLSSelfSigned    bool   `json:"tls_self_signed,omitempty"`    // 未配置证书文件时，首次运行在 etc/tls 生成本地 CA 和证书
+	HTTPRedirectPort int    `json:"http_redirect_port,omitempty"` // 启用 HTTPS 时在该端口监听 HTTP 并重定向到 HTTPS (0表示不监听)
+	HTTP3            bool   `json:"http3,omitempty"`              // 启用 HTTPS 时在 listen_port 的 UDP 上同时提供 HTTP/3，并通过 Alt-Svc 通告
+
+	STAMPPort int `json:"stamp_port,omitempty"` // STAMP/TWAMP-Light 反射器 UDP 端口 (0表示不启用，标准端口为862)
+
+	ICETCPPort int `json:"ice_tcp_port,omitempty"` // ICE-TCP 被动候选的固定 TCP 端口 (0表示只在仅 TCP 策略下随机监听)
+
+	STUNPort    int    `json:"stun_port,omitempty"`     // 内置 STUN 服务器 UDP 端口 (0表示标准端口3478，负数表示不启用)
+	STUNAltPort int    `json:"stun_alt_port,omitempty"` // RFC 5780 备用端口 (0表示 stun_port+1)
+	STUNIP      string `json:"stun_ip,omitempty"`       // STUN 主地址，配置备用地址时必填
+	STUNAltIP   string `json:"stun_alt_ip,omitempty"`   // STUN 备用地址（第二个本机公网IP，留空则只能测试端口变化）
+
+	TURNURLs       []string `json:"turn_urls,omitempty"`       // TURN 中继服务器地址（如 turn:turn.example.com:3478?transport=udp），用于仅中继传输策略
+	TURNUsername   string   `json:"turn_username,omitempty"`   // TURN 用户名
+	TURNCredential string   `json:"turn_credential,omitempty"` // TURN 密码
+	TURNSecret     string   `json:"turn_secret,omitempty"`     // TURN REST API 共享密钥（coturn use-auth-secret），设置后为每次请求生成临时凭据
+
+	Impairment *impair.Config `json:"impairment,omitempty"` // 回显路径和测速接口默认模拟的网络损伤 (留空表示不模拟)
 }
 
 func LoadConfig(path string) (Config, error) {
@@ -23,10 +58,10 @@ func LoadConfig(path string) (Config, error) {
 			return Config{}, fmt.Errorf("failed to create config directory: %w", err)
 		}
 		defaultConfig := Config{
-			ListenPort: 52611,
-			PublicIP:   "",  // 留空表示自动检测，或手动填写公网IP
-			UDPPortMin: 0,   // 0表示使用随机端口
-			UDPPortMax: 0,   // 0表示使用随机端口
+			ListenPort:      52611,
+			PublicIP:        "", // 留空表示自动检测，或手动填写公网IP
+			UDPPortMin:      0,  // 0表示使用随机端口
+			UDPPortMax:      0,  // 0表示使用随机端口
 			IPAPICustomHost: "",
 		}
 		configData, err := json.MarshalIndent(defaultConfig, "", "  ")
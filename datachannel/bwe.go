@@ -0,0 +1,255 @@
+package datachannel
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	bweInitialBitrate = 300_000 // 拥塞控制器的初始目标码率 (bps)
+	bweFrameInterval  = 10 * time.Millisecond
+	maxBWEPoints      = 3600 // 每秒一个采样点，逐秒数据只保留最近一小时，汇总和最终值覆盖整个测试
+)
+
+// bweLevels 汇总时使用的码率档位 (kbps)，每个采样点归入不超过其发送码率的最高档
+var bweLevels = []int{0, 100, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// BWEOptions 带宽估计测试参数，MaxKbps 为发送码率上限（0 表示 maxMediaKbps）
+type BWEOptions struct {
+	MaxKbps int `json:"maxKbps"`
+}
+
+// BWEPoint 一个采样周期的估计值和网络状况
+type BWEPoint struct {
+	Offset        float64 `json:"offset"`        // 距测试开始的毫秒数
+	EstimateKbps  float64 `json:"estimateKbps"`  // GCC 目标码率
+	SendKbps      float64 `json:"sendKbps"`      // 本周期实际发送码率
+	LossRatio     float64 `json:"lossRatio"`     // 客户端 RTCP 接收报告中的近期丢包比例
+	RoundTripTime float64 `json:"roundTripTime"` // 毫秒
+	DelayGradient float64 `json:"delayGradient"` // GCC 排队延迟梯度估计，毫秒
+	Usage         string  `json:"usage"`         // GCC 过载检测结果：normal / over / under
+}
+
+// BWELevel 某一码率档位下的平均丢包和延迟
+type BWELevel struct {
+	Kbps          int     `json:"kbps"`
+	Points        int     `json:"points"`
+	LossRatio     float64 `json:"lossRatio"`
+	RoundTripTime float64 `json:"roundTripTime"`
+	DelayGradient float64 `json:"delayGradient"`
+}
+
+// BWEReport 带宽估计测试结果
+type BWEReport struct {
+	FinalKbps float64    `json:"finalKbps"`
+	PeakKbps  float64    `json:"peakKbps"`
+	Levels    []BWELevel `json:"levels"`
+	Points    []BWEPoint `json:"points,omitempty"`
+}
+
+// BWETest 按 GCC 目标码率发送合成视频流，逐步探测可持续的实时码率
+type BWETest struct {
+	peer    *Peer
+	maxBits int
+	ssrc    uint32
+	start   time.Time
+	done    chan struct{}
+
+	mu        sync.Mutex
+	points    []BWEPoint // 最近 maxBWEPoints 个采样点
+	levels    []BWELevel // 各码率档位的累计值，Report 时取平均
+	finalKbps float64
+	peakKbps  float64
+	lastBytes uint64
+	lastTime  time.Time
+}
+
+// StartBWE 添加由拥塞控制器驱动码率的视频轨道，必须在处理客户端 offer 之前调用。
+// 客户端需要提供一个接收视频的 transceiver 并在 SDP 中协商 transport-cc。每个会话只能调用一次
+func (p *Peer) StartBWE(opts BWEOptions) (*BWETest, error) {
+	if opts.MaxKbps < 0 || opts.MaxKbps > maxMediaKbps {
+		return nil, fmt.Errorf("bandwidth estimation limit must be between 0 and %d kbps", maxMediaKbps)
+	}
+	if p.estimator == nil {
+		return nil, errors.New("congestion controller is not available")
+	}
+	if !p.bweStarted.CompareAndSwap(false, true) {
+		return nil, errors.New("bandwidth estimation already started")
+	}
+	if opts.MaxKbps == 0 {
+		opts.MaxKbps = maxMediaKbps
+	}
+
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		"bwe", "pltester-bwe",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bwe track: %w", err)
+	}
+	sender, err := p.AddTrack(track)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add bwe track: %w", err)
+	}
+
+	b := &BWETest{
+		peer:    p,
+		maxBits: opts.MaxKbps * 1000,
+		ssrc:    uint32(sender.GetParameters().Encodings[0].SSRC),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			if _, _, err := sender.ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
+	go b.send(track)
+	return b, nil
+}
+
+// send 每 10ms 写入一帧，帧大小按当前目标码率计算。在连接建立前不计时
+func (b *BWETest) send(track *webrtc.TrackLocalStaticSample) {
+	ticker := time.NewTicker(bweFrameInterval)
+	defer ticker.Stop()
+	payload := make([]byte, b.maxBits/8/int(time.Second/bweFrameInterval)+1)
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+		if b.peer.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			continue
+		}
+		b.mu.Lock()
+		if b.start.IsZero() {
+			b.start = time.Now()
+			b.lastTime = b.start
+		}
+		b.mu.Unlock()
+
+		bits := b.targetBitrate()
+		frame := payload[:bits/8/int(time.Second/bweFrameInterval)+1]
+		rand.Read(frame)
+		if err := track.WriteSample(media.Sample{Data: frame, Duration: bweFrameInterval}); err != nil {
+			log.Printf("Failed to write bwe sample: %v", err)
+			return
+		}
+	}
+}
+
+func (b *BWETest) targetBitrate() int {
+	bits := b.peer.estimator.GetTargetBitrate()
+	if bits > b.maxBits {
+		bits = b.maxBits
+	}
+	return bits
+}
+
+// Sample 记录当前估计值和网络状况，应每秒调用一次。连接建立前返回 false
+func (b *BWETest) Sample() (BWEPoint, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.start.IsZero() {
+		return BWEPoint{}, false
+	}
+
+	now := time.Now()
+	point := BWEPoint{
+		Offset:       float64(now.Sub(b.start)) / float64(time.Millisecond),
+		EstimateKbps: float64(b.peer.estimator.GetTargetBitrate()) / 1000,
+	}
+	if b.peer.rtpStats != nil {
+		if st := b.peer.rtpStats.Get(b.ssrc); st != nil {
+			bytes := st.OutboundRTPStreamStats.BytesSent
+			if elapsed := now.Sub(b.lastTime).Seconds(); elapsed > 0 {
+				point.SendKbps = float64(bytes-b.lastBytes) * 8 / elapsed / 1000
+			}
+			b.lastBytes = bytes
+			point.LossRatio = st.RemoteInboundRTPStreamStats.FractionLost
+			point.RoundTripTime = float64(st.RemoteInboundRTPStreamStats.RoundTripTime) / float64(time.Millisecond)
+		}
+	}
+	b.lastTime = now
+
+	stats := b.peer.estimator.GetStats()
+	if v, ok := stats["delayEstimate"].(float64); ok {
+		point.DelayGradient = v
+	}
+	if v, ok := stats["usage"].(string); ok {
+		point.Usage = v
+	}
+
+	b.record(point)
+	return point, true
+}
+
+// record 将采样点计入汇总，并只保留最近 maxBWEPoints 个
+func (b *BWETest) record(p BWEPoint) {
+	if b.levels == nil {
+		b.levels = make([]BWELevel, len(bweLevels))
+		for i, kbps := range bweLevels {
+			b.levels[i].Kbps = kbps
+		}
+	}
+	if p.EstimateKbps > b.peakKbps {
+		b.peakKbps = p.EstimateKbps
+	}
+	b.finalKbps = p.EstimateKbps
+
+	i := len(bweLevels) - 1
+	for i > 0 && p.SendKbps < float64(bweLevels[i]) {
+		i--
+	}
+	l := &b.levels[i]
+	l.Points++
+	l.LossRatio += p.LossRatio
+	l.RoundTripTime += p.RoundTripTime
+	l.DelayGradient += p.DelayGradient
+
+	b.points = append(b.points, p)
+	if over := len(b.points) - maxBWEPoints; over > 0 {
+		b.points = b.points[over:]
+	}
+}
+
+// Report 汇总各码率档位下的丢包和延迟，withPoints 为 false 时省略逐秒数据
+func (b *BWETest) Report(withPoints bool) BWEReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	report := BWEReport{FinalKbps: b.finalKbps, PeakKbps: b.peakKbps, Levels: []BWELevel{}}
+	for _, l := range b.levels {
+		if l.Points == 0 {
+			continue
+		}
+		n := float64(l.Points)
+		l.LossRatio /= n
+		l.RoundTripTime /= n
+		l.DelayGradient /= n
+		report.Levels = append(report.Levels, l)
+	}
+	if withPoints {
+		report.Points = append([]BWEPoint(nil), b.points...)
+	}
+	return report
+}
+
+// Close 停止发送
+func (b *BWETest) Close() {
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 
 	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/webrtc/v3"
 	"golang.org/x/net/websocket"
 )
@@ -23,21 +28,105 @@ func InitializePeerConnectionWithPublicIP(publicIP string) (*webrtc.PeerConnecti
 
 // InitializePeerConnectionWithConfig 使用完整配置初始化 WebRTC PeerConnection
 func InitializePeerConnectionWithConfig(publicIP string, udpPortMin, udpPortMax uint16) (*webrtc.PeerConnection, error) {
+	peer, err := NewPeer(Options{
+		PublicIP:   publicIP,
+		UDPPortMin: udpPortMin,
+		UDPPortMax: udpPortMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return peer.PeerConnection, nil
+}
+
+// PublicSTUNServers 未启用内置 STUN 服务器时使用的公共 STUN 服务器
+var PublicSTUNServers = []string{
+	"stun:stun.l.google.com:19302",
+	"stun:stun1.l.google.com:19302",
+	"stun:stun2.l.google.com:19302",
+	"stun:stun.sipgate.net:3478",
+}
+
+// 双栈对比测试可选的地址族
+const (
+	NetworkIPv4 = "ipv4"
+	NetworkIPv6 = "ipv6"
+)
+
+// NetworkTypesFor 返回只收集指定地址族候选的网络类型，family 为空时返回 nil（使用全部类型）
+func NetworkTypesFor(family string) ([]webrtc.NetworkType, error) {
+	switch family {
+	case "":
+		return nil, nil
+	case NetworkIPv4:
+		return []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeTCP4}, nil
+	case NetworkIPv6:
+		return []webrtc.NetworkType{webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP6}, nil
+	default:
+		return nil, fmt.Errorf("unknown network family %q", family)
+	}
+}
+
+// Options 服务端 PeerConnection 的配置
+type Options struct {
+	PublicIP     string               // 公网IP，用于 NAT 1:1 映射
+	UDPPortMin   uint16               // UDP端口范围最小值 (0表示随机)
+	UDPPortMax   uint16               // UDP端口范围最大值 (0表示随机)
+	STUNServers  []string             // STUN 服务器地址，留空则使用 PublicSTUNServers
+	NetworkTypes []webrtc.NetworkType // 候选的网络类型，留空则 IPv4/IPv6 的 UDP 和 TCP 全部启用
+	Transport    string               // 传输策略（TransportUDP / TransportTCP / TransportRelay），留空不限制
+	TURN         TURNConfig           // TURN 中继服务器，TransportRelay 时必须配置
+	TCPMux       ice.TCPMux           // 共用的固定端口 ICE-TCP 监听，留空时仅 TCP 策略的会话单独随机监听
+}
+
+// Peer 服务端 PeerConnection 及其 RTP/RTCP 统计和带宽估计器
+type Peer struct {
+	*webrtc.PeerConnection
+	rtpStats  stats.Getter
+	estimator cc.BandwidthEstimator
+	tcpMux    ice.TCPMux // 未配置共用监听时，仅 TCP 策略的会话独占的 ICE-TCP 监听
+
+	// 预协商通道的 ID 固定，每个会话只能创建一次
+	profilesOpened    atomic.Bool
+	throughputStarted atomic.Bool
+	// 媒体测试会添加本地轨道并接管 OnTrack，每个会话只能启动一次
+	mediaStarted atomic.Bool
+	// 带宽估计的视频轨道共用本会话唯一的估计器，每个会话只能启动一次
+	bweStarted atomic.Bool
+}
+
+// Close 关闭 PeerConnection 及本会话的 ICE-TCP 监听
+func (p *Peer) Close() error {
+	err := p.PeerConnection.Close()
+	if p.tcpMux != nil {
+		p.tcpMux.Close()
+	}
+	return err
+}
+
+// NewPeer 按配置创建 PeerConnection，注册默认编解码器、拦截器（NACK、RTCP 报告）、
+// 统计拦截器和基于 TWCC 反馈的 GCC 拥塞控制器
+func NewPeer(opts Options) (*Peer, error) {
+	publicIP, udpPortMin, udpPortMax := opts.PublicIP, opts.UDPPortMin, opts.UDPPortMax
+	if err := ValidateTransport(opts.Transport, opts.TURN); err != nil {
+		return nil, err
+	}
+
 	// 创建 SettingEngine 以配置 NAT 类型
 	settingEngine := webrtc.SettingEngine{}
-	
+
 	// 如果提供了公网IP或设置了环境变量，则配置 NAT 1to1 映射
 	if publicIP == "" {
 		publicIP = os.Getenv("PUBLIC_IP")
 	}
-	
+
 	if publicIP != "" {
 		log.Printf("Using public IP for NAT 1:1 mapping: %s", publicIP)
 		settingEngine.SetNAT1To1IPs([]string{publicIP}, webrtc.ICECandidateTypeHost)
 	} else {
 		log.Println("No public IP configured, using default NAT traversal")
 	}
-	
+
 	// 配置UDP端口范围
 	if udpPortMin > 0 && udpPortMax > 0 && udpPortMax >= udpPortMin {
 		log.Printf("Setting UDP port range: %d-%d", udpPortMin, udpPortMax)
@@ -49,14 +138,24 @@ func InitializePeerConnectionWithConfig(publicIP string, udpPortMin, udpPortMax
 	} else {
 		log.Println("Using random UDP ports (no range specified)")
 	}
-	
-	// 设置网络类型，允许所有类型的候选
-	settingEngine.SetNetworkTypes([]webrtc.NetworkType{
-		webrtc.NetworkTypeUDP4,
-		webrtc.NetworkTypeUDP6,
-		webrtc.NetworkTypeTCP4,
-		webrtc.NetworkTypeTCP6,
-	})
+
+	// 设置网络类型，默认允许所有类型的候选；双栈对比测试只启用一个地址族，
+	// 由于 ICE 只在同一地址族的候选之间配对，连接必然走该地址族的路径
+	networkTypes := opts.NetworkTypes
+	if len(networkTypes) == 0 {
+		networkTypes = []webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4,
+			webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4,
+			webrtc.NetworkTypeTCP6,
+		}
+	}
+	// 传输策略进一步限定 UDP 或 TCP；没有可用的网络类型时 ICE 不会收集任何候选
+	networkTypes = filterNetworkTypes(networkTypes, opts.Transport)
+	if len(networkTypes) == 0 {
+		return nil, fmt.Errorf("no network types left for transport %q", opts.Transport)
+	}
+	settingEngine.SetNetworkTypes(networkTypes)
 
 	// 如果配置了公网IP和端口范围，则不需要STUN服务器
 	var config webrtc.Configuration
@@ -68,36 +167,184 @@ func InitializePeerConnectionWithConfig(publicIP string, udpPortMin, udpPortMax
 			ICECandidatePoolSize: 10,
 		}
 	} else {
-		// 没有公网IP配置，使用STUN服务器
-		log.Println("Using STUN servers for NAT traversal")
+		// 没有公网IP配置，使用STUN服务器（优先使用节点内置的 STUN 服务器）
+		stunServers := opts.STUNServers
+		if len(stunServers) == 0 {
+			stunServers = PublicSTUNServers
+		}
+		log.Printf("Using STUN servers for NAT traversal: %v", stunServers)
 		config = webrtc.Configuration{
-			ICEServers: []webrtc.ICEServer{
-				{
-					URLs: []string{
-						"stun:stun.l.google.com:19302",
-						"stun:stun1.l.google.com:19302",
-						"stun:stun2.l.google.com:19302",
-						"stun:stun.sipgate.net:3478",
-					},
-				},
-			},
+			ICEServers:           []webrtc.ICEServer{{URLs: stunServers}},
 			ICECandidatePoolSize: 10,
 		}
 	}
 
+	// 仅中继策略：只收集 TURN 中继候选，连接必然经过 TURN 服务器
+	if opts.Transport == TransportRelay {
+		log.Printf("Using TURN relay only: %v", opts.TURN.URLs)
+		config.ICEServers = []webrtc.ICEServer{opts.TURN.ICEServer()}
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+
+	// 注册编解码器和拦截器，用于 RTP 媒体流测试
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+	registry := &interceptor.Registry{}
+	peer := &Peer{}
+
+	// 拥塞控制器只做估计、不做节流（NoOp pacer），由带宽估计测试按目标码率发送，
+	// 必须在 TWCC 头部扩展拦截器之前注册，才能读取到传输序号。
+	// 带宽估计请求在 PeerConnection 创建之后才到达，而拦截器须在创建时注册，因此每个会话都注册；
+	// 拦截器只处理 RTP/RTCP，不参与 DataChannel（SCTP）收发，未启用媒体测试的会话
+	// 只多出一个估计器及其两个阻塞等待的协程
+	ccFactory, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(bweInitialBitrate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create congestion controller: %w", err)
+	}
+	ccFactory.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		peer.estimator = estimator
+	})
+	registry.Add(ccFactory)
+
+	// 统计拦截器须位于 RTCP 报告拦截器内侧，才能记录发出的 SR 并计算 RTT
+	statsFactory, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stats interceptor: %w", err)
+	}
+	statsFactory.OnNewPeerConnection(func(_ string, getter stats.Getter) {
+		peer.rtpStats = getter
+	})
+	registry.Add(statsFactory)
+
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("failed to configure TWCC: %w", err)
+	}
+
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	// ICE-TCP 只收集被动候选，需要监听 TCP 端口：优先使用配置的固定端口（与 UDP 候选一样
+	// 按 NAT 1:1 映射通告公网IP），未配置时仅 TCP 策略下为本会话随机监听一个端口
+	var tcpMux ice.TCPMux
+	if opts.TCPMux != nil {
+		settingEngine.SetICETCPMux(opts.TCPMux)
+	} else if opts.Transport == TransportTCP {
+		if tcpMux, err = ListenICETCP(0); err != nil {
+			return nil, err
+		}
+		settingEngine.SetICETCPMux(tcpMux)
+	}
+
 	// 使用 API 创建 PeerConnection
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	api := webrtc.NewAPI(
+		webrtc.WithSettingEngine(settingEngine),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	)
 	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
+		if tcpMux != nil {
+			tcpMux.Close()
+		}
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
 	}
-	
+	peer.PeerConnection = peerConnection
+	peer.tcpMux = tcpMux
+
 	log.Println("PeerConnection initialized with NAT configuration")
-	return peerConnection, nil
+	return peer, nil
+}
+
+// ProbeChannelLabel 延迟/丢包探测通道的名称，与浏览器创建的通道相同
+const ProbeChannelLabel = "dataChannel"
+
+// NewProbeChannel 由服务端创建无序、不重传的探测通道，用于服务端发起协商的模式
+func NewProbeChannel(peerConnection *webrtc.PeerConnection) (*webrtc.DataChannel, error) {
+	ordered := false
+	maxRetransmits := uint16(0)
+	d, err := peerConnection.CreateDataChannel(ProbeChannelLabel, &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe channel: %w", err)
+	}
+	return d, nil
+}
+
+// Signaler 信令发送端。会话可在 WebSocket 断线重连后继续，后台回调须发往当前连接，
+// 因此这里不直接持有 *websocket.Conn
+type Signaler interface {
+	Send(msg string) error
+}
+
+// WebSocketSignaler 直接发往固定的 WebSocket 连接
+type WebSocketSignaler struct {
+	*websocket.Conn
+}
+
+// Send 发送一条文本消息
+func (s WebSocketSignaler) Send(msg string) error {
+	return websocket.Message.Send(s.Conn, msg)
+}
+
+// SendOffer 由服务端生成 offer 并通过信令发送，客户端只需应答，适合嵌入式设备和命令行等简化客户端。
+// 此前添加的数据通道、媒体轨道会一并出现在 offer 中，通道参数和编解码器均由服务端决定
+func SendOffer(peerConnection *webrtc.PeerConnection, sig Signaler) error {
+	if err := CheckOffer(peerConnection); err != nil {
+		return err
+	}
+	return sendOffer(peerConnection, nil, sig)
+}
+
+// CheckOffer 检查 PeerConnection 是否尚未协商，只有此时服务端才能发起 offer
+func CheckOffer(peerConnection *webrtc.PeerConnection) error {
+	if peerConnection.SignalingState() != webrtc.SignalingStateStable || peerConnection.RemoteDescription() != nil {
+		return fmt.Errorf("peer connection already negotiated")
+	}
+	return nil
+}
+
+// RestartICE 以新的 ufrag/pwd 重新协商 ICE，客户端网络切换（如 Wi-Fi 换到蜂窝）后
+// 原候选对失效时使用，数据通道和 SCTP 关联保持不变
+func RestartICE(peerConnection *webrtc.PeerConnection, sig Signaler) error {
+	if peerConnection.RemoteDescription() == nil {
+		return fmt.Errorf("peer connection not negotiated")
+	}
+	if peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		return fmt.Errorf("negotiation already in progress")
+	}
+	return sendOffer(peerConnection, &webrtc.OfferOptions{ICERestart: true}, sig)
+}
+
+func sendOffer(peerConnection *webrtc.PeerConnection, options *webrtc.OfferOptions, sig Signaler) error {
+	offer, err := peerConnection.CreateOffer(options)
+	if err != nil {
+		return fmt.Errorf("failed to create offer: %w", err)
+	}
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("failed to set local description: %w", err)
+	}
+	offerJSON, err := json.Marshal(offer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal offer: %w", err)
+	}
+	if err := sig.Send(string(offerJSON)); err != nil {
+		return fmt.Errorf("failed to send offer: %w", err)
+	}
+	return nil
 }
 
 // HandleICECandidate 处理 ICE 候选
-func HandleICECandidate(peerConnection *webrtc.PeerConnection, ws *websocket.Conn) {
+func HandleICECandidate(peerConnection *webrtc.PeerConnection, sig Signaler) {
 	peerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
 		if c == nil {
 			return
@@ -108,21 +355,21 @@ func HandleICECandidate(peerConnection *webrtc.PeerConnection, ws *websocket.Con
 			log.Println("Failed to marshal ICE candidate:", err)
 			return
 		}
-		if err := websocket.Message.Send(ws, string(candidateJSON)); err != nil {
+		if err := sig.Send(string(candidateJSON)); err != nil {
 			log.Println("Failed to send ICE candidate:", err)
 		}
 	})
 }
 
 // HandleSDP 处理 SDP 消息
-func HandleSDP(peerConnection *webrtc.PeerConnection, msg string, ws *websocket.Conn) error {
+func HandleSDP(peerConnection *webrtc.PeerConnection, msg string, sig Signaler) error {
 	var sdp webrtc.SessionDescription
 	if err := json.Unmarshal([]byte(msg), &sdp); err != nil {
 		return fmt.Errorf("failed to unmarshal SDP: %w", err)
 	}
 
 	switch sdp.Type {
-case webrtc.SDPTypeOffer:
+	case webrtc.SDPTypeOffer:
 		if err := peerConnection.SetRemoteDescription(sdp); err != nil {
 			return fmt.Errorf("failed to set remote description: %w", err)
 		}
@@ -140,7 +387,7 @@ case webrtc.SDPTypeOffer:
 		if err != nil {
 			return fmt.Errorf("failed to marshal answer: %w", err)
 		}
-		if err := websocket.Message.Send(ws, string(answerJSON)); err != nil {
+		if err := sig.Send(string(answerJSON)); err != nil {
 			return fmt.Errorf("failed to send answer: %w", err)
 		}
 	case webrtc.SDPTypeAnswer:
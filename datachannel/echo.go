@@ -0,0 +1,108 @@
+package datachannel
+
+import (
+	"log"
+	"sync/atomic"
+
+	"pltester/impair"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// echoFlushEvery 每个通道累计多少条消息后写入一次共享计数
+const echoFlushEvery = 64
+
+// EchoStats 回显计数，可被任意多个通道共享。各通道先在本地累计，
+// 每 echoFlushEvery 条消息或通道关闭时用原子操作批量写入，热路径上没有锁。
+type EchoStats struct {
+	messages atomic.Uint64
+	bytes    atomic.Uint64
+	errors   atomic.Uint64
+}
+
+// EchoSnapshot 某一时刻的回显计数
+type EchoSnapshot struct {
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+	Errors   uint64 `json:"errors"`
+}
+
+// Snapshot 返回已写入的计数，尚未批量写入的部分不包含在内
+func (s *EchoStats) Snapshot() EchoSnapshot {
+	return EchoSnapshot{
+		Messages: s.messages.Load(),
+		Bytes:    s.bytes.Load(),
+		Errors:   s.errors.Load(),
+	}
+}
+
+// echoCounter 单个通道的本地计数。pion 在同一个读取协程中依次调用 OnMessage，无需同步
+type echoCounter struct {
+	stats    *EchoStats
+	messages uint64
+	bytes    uint64
+	errors   uint64
+}
+
+func (c *echoCounter) flush() {
+	if c.messages > 0 {
+		c.stats.messages.Add(c.messages)
+		c.stats.bytes.Add(c.bytes)
+	}
+	if c.errors > 0 {
+		c.stats.errors.Add(c.errors)
+	}
+	c.messages, c.bytes, c.errors = 0, 0, 0
+}
+
+// Echo 将通道收到的消息原样回显。二进制消息直接回发 pion 为该消息分配的缓冲区，不做复制；
+// 文本消息仍以文本回发以兼容旧客户端。发送失败只记录每个通道的第一次错误，其余计数。
+//
+// link 非 nil 时回显经过模拟的网络损伤：被丢弃的消息不回显也不计数，
+// 延迟的消息在 link 的协程中按其决定的顺序发送。
+func Echo(d *webrtc.DataChannel, stats *EchoStats, link *impair.Link, connID string) {
+	counter := &echoCounter{stats: stats}
+	var logged atomic.Bool
+
+	echo := func(msg webrtc.DataChannelMessage) error {
+		var err error
+		if msg.IsString {
+			err = d.SendText(string(msg.Data))
+		} else {
+			err = d.Send(msg.Data)
+		}
+		if err != nil && logged.CompareAndSwap(false, true) {
+			log.Printf("Failed to echo on %s for connection %s: %v", d.Label(), connID, err)
+		}
+		return err
+	}
+
+	d.OnOpen(func() {
+		log.Printf("Data channel %s opened for connection: %s", d.Label(), connID)
+	})
+	d.OnClose(func() {
+		// pion 在读取循环退出后才启动关闭回调，不会与 OnMessage 并发
+		counter.flush()
+	})
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if link != nil {
+			// 延迟发送在其他协程中进行，发送失败直接写入共享计数
+			if link.Send(len(msg.Data), func() {
+				if echo(msg) != nil {
+					stats.errors.Add(1)
+				}
+			}) {
+				counter.messages++
+				counter.bytes += uint64(len(msg.Data))
+			}
+		} else if err := echo(msg); err != nil {
+			counter.errors++
+		} else {
+			counter.messages++
+			counter.bytes += uint64(len(msg.Data))
+		}
+		if counter.messages+counter.errors >= echoFlushEvery {
+			counter.flush()
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package datachannel
+
+import (
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// echoWindow 每个连接允许未回显的消息数，使基准测量回显速率而不是队列增长
+const echoWindow = 256
+
+// echoPair 一个回环连接及其尚未回显的消息计数
+type echoPair struct {
+	*LoopbackEcho
+	echoed sync.WaitGroup
+}
+
+func newEchoPair(b *testing.B, stats *EchoStats) *echoPair {
+	b.Helper()
+	p := &echoPair{}
+	l, err := NewLoopbackEcho(stats, echoWindow, p.echoed.Done)
+	if err != nil {
+		b.Fatal(err)
+	}
+	p.LoopbackEcho = l
+	return p
+}
+
+// send 发送 n 条消息并等待全部回显
+func (p *echoPair) send(b *testing.B, n int) {
+	msg := make([]byte, 12)
+	p.echoed.Add(n)
+	for i := 0; i < n; i++ {
+		if err := p.Send(msg); err != nil {
+			b.Error(err)
+			return
+		}
+	}
+	p.echoed.Wait()
+}
+
+// BenchmarkEcho 测量回环上每个核心每秒回显的消息数，每个 GOMAXPROCS 对应一个连接，
+// 以 -cpu 指定核心数。客户端也在本进程内运行，结果是服务端回显能力的下限
+func BenchmarkEcho(b *testing.B) {
+	// 建立连接时 NewPeer 会逐条打印配置
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var stats EchoStats
+	procs := runtime.GOMAXPROCS(0)
+	pairs := make([]*echoPair, procs)
+	for i := range pairs {
+		pairs[i] = newEchoPair(b, &stats)
+		defer pairs[i].Close()
+	}
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i, p := range pairs {
+		n := b.N / procs
+		if i < b.N%procs {
+			n++
+		}
+		wg.Add(1)
+		go func(p *echoPair, n int) {
+			defer wg.Done()
+			p.send(b, n)
+		}(p, n)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds()/float64(procs), "msgs/s/core")
+	if errs := stats.Snapshot().Errors; errs > 0 {
+		b.Errorf("%d echo errors", errs)
+	}
+}
@@ -0,0 +1,99 @@
+package datachannel
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// loopbackOpenTimeout 等待回环数据通道打开的最长时间
+const loopbackOpenTimeout = 10 * time.Second
+
+// LoopbackEcho 本进程内经回环地址连接的一对 PeerConnection，服务端一侧以 Echo 回显客户端通道上的消息，
+// 用于测量回显路径每秒能处理的消息数。最多允许 window 条消息未回显，测量的是回显速率而不是队列增长
+type LoopbackEcho struct {
+	server, client *Peer
+	channel        *webrtc.DataChannel
+	tokens         chan struct{}
+}
+
+// NewLoopbackEcho 建立回环连接并等待数据通道打开，每收到一条回显调用一次 onEcho
+func NewLoopbackEcho(stats *EchoStats, window int, onEcho func()) (*LoopbackEcho, error) {
+	server, err := NewPeer(Options{})
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewPeer(Options{})
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	l := &LoopbackEcho{server: server, client: client, tokens: make(chan struct{}, window)}
+
+	server.OnDataChannel(func(d *webrtc.DataChannel) {
+		Echo(d, stats, nil, "loopback")
+	})
+	l.channel, err = client.CreateDataChannel("loopback", nil)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	opened := make(chan struct{})
+	l.channel.OnOpen(func() { close(opened) })
+	l.channel.OnMessage(func(webrtc.DataChannelMessage) {
+		<-l.tokens
+		onEcho()
+	})
+
+	if err := connectLoopback(client.PeerConnection, server.PeerConnection); err != nil {
+		l.Close()
+		return nil, err
+	}
+	select {
+	case <-opened:
+	case <-time.After(loopbackOpenTimeout):
+		l.Close()
+		return nil, errors.New("data channel did not open")
+	}
+	return l, nil
+}
+
+// Send 发送一条消息，未回显的消息达到 window 条时阻塞
+func (l *LoopbackEcho) Send(msg []byte) error {
+	l.tokens <- struct{}{}
+	// pion 在发送完成前持有缓冲区
+	return l.channel.Send(append([]byte(nil), msg...))
+}
+
+// Close 关闭两端的 PeerConnection
+func (l *LoopbackEcho) Close() {
+	l.client.Close()
+	l.server.Close()
+}
+
+// connectLoopback 在两个本地 PeerConnection 之间完成不使用 trickle ICE 的 offer/answer 交换
+func connectLoopback(offerer, answerer *webrtc.PeerConnection) error {
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(offerer)
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	<-gathered
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		return err
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	gathered = webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	<-gathered
+	return offerer.SetRemoteDescription(*answerer.LocalDescription())
+}
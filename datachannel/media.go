@@ -0,0 +1,264 @@
+package datachannel
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	audioFrameInterval = 20 * time.Millisecond
+	videoFrameInterval = time.Second / 30
+	maxMediaKbps       = 20000
+	// Opus 每帧独占一个 RTP 包且不分片：510 kbps 是 Opus 的最高码率，
+	// 20 ms 帧为 1275 字节（Opus 单帧上限），加上 RTP/SRTP/UDP/IP 头部仍小于 1500 字节 MTU
+	maxAudioKbps       = 510
+	maxAudioFrameBytes = 1275
+)
+
+// MediaOptions 合成 RTP 媒体流测试参数（码率单位 kbps，0 表示不发送该类型）
+type MediaOptions struct {
+	AudioKbps int `json:"audioKbps"`
+	VideoKbps int `json:"videoKbps"`
+}
+
+// StreamStats 单个 RTP 流的统计
+//
+// 发送方向（服务端→客户端）的丢包和抖动来自客户端的 RTCP 接收报告，
+// 接收方向（客户端→服务端）由服务端按序列号统计丢包，按 RFC 3550 计算到达间隔抖动。
+type StreamStats struct {
+	Kind          string  `json:"kind"`      // audio / video
+	Direction     string  `json:"direction"` // send / recv（以服务端为视角）
+	SSRC          uint32  `json:"ssrc"`
+	Packets       uint64  `json:"packets"`
+	Bytes         uint64  `json:"bytes"`
+	PacketsLost   int64   `json:"packetsLost"`
+	FractionLost  float64 `json:"fractionLost"`            // 最近一个 RTCP 报告周期的丢包比例
+	Jitter        float64 `json:"jitter"`                  // 毫秒
+	RoundTripTime float64 `json:"roundTripTime,omitempty"` // 毫秒，由 RTCP SR/RR 计算
+}
+
+// MediaReport 媒体流测试结果
+type MediaReport struct {
+	Streams []StreamStats `json:"streams"`
+}
+
+type mediaStream struct {
+	kind      string
+	ssrc      uint32
+	clockRate float64
+}
+
+// remoteStream 客户端发来的流。pion 统计拦截器的接收抖动按相邻到达间隔计算传输时间，
+// 结果不符合 RFC 3550，这里在读取循环中自行计算。
+type remoteStream struct {
+	mediaStream
+	started     bool
+	lastArrival time.Time
+	lastRTPTime uint32
+	jitter      float64 // RTP 时间戳单位
+}
+
+// observe 按 RFC 3550 6.4.1 更新到达间隔抖动
+func (r *remoteStream) observe(arrival time.Time, rtpTime uint32) {
+	if r.started {
+		arrivalDelta := arrival.Sub(r.lastArrival).Seconds() * r.clockRate
+		d := arrivalDelta - float64(int32(rtpTime-r.lastRTPTime))
+		if d < 0 {
+			d = -d
+		}
+		r.jitter += (d - r.jitter) / 16
+	}
+	r.started = true
+	r.lastArrival = arrival
+	r.lastRTPTime = rtpTime
+}
+
+// MediaTest 服务端发送和接收的合成 RTP 音视频流
+type MediaTest struct {
+	peer *Peer
+	done chan struct{}
+
+	mu     sync.Mutex
+	local  []mediaStream
+	remote []*remoteStream
+}
+
+// StartMedia 添加合成音视频轨道并接收客户端轨道，必须在处理客户端 offer 之前调用，每个会话只能调用一次
+func (p *Peer) StartMedia(opts MediaOptions) (*MediaTest, error) {
+	if opts.AudioKbps < 0 || opts.AudioKbps > maxAudioKbps || frameBytes(opts.AudioKbps, audioFrameInterval) > maxAudioFrameBytes {
+		return nil, fmt.Errorf("audio bitrate must be between 0 and %d kbps", maxAudioKbps)
+	}
+	if opts.VideoKbps < 0 || opts.VideoKbps > maxMediaKbps {
+		return nil, fmt.Errorf("video bitrate must be between 0 and %d kbps", maxMediaKbps)
+	}
+	if !p.mediaStarted.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("media test already started")
+	}
+
+	m := &MediaTest{peer: p, done: make(chan struct{})}
+
+	p.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		stream := &remoteStream{mediaStream: mediaStream{
+			kind:      track.Kind().String(),
+			ssrc:      uint32(track.SSRC()),
+			clockRate: float64(track.Codec().ClockRate),
+		}}
+		m.mu.Lock()
+		m.remote = append(m.remote, stream)
+		m.mu.Unlock()
+
+		// 读取客户端的 SR，接收报告中才会带上 LSR/DLSR，客户端据此计算 RTT
+		go func() {
+			for {
+				if _, _, err := receiver.ReadRTCP(); err != nil {
+					return
+				}
+			}
+		}()
+
+		// 持续读取以驱动拦截器统计和 RTCP 接收报告
+		for {
+			packet, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			stream.observe(time.Now(), packet.Timestamp)
+			m.mu.Unlock()
+		}
+	})
+
+	if opts.AudioKbps > 0 {
+		if err := m.addTrack(webrtc.MimeTypeOpus, 48000, "audio", opts.AudioKbps, audioFrameInterval); err != nil {
+			return nil, err
+		}
+	}
+	if opts.VideoKbps > 0 {
+		if err := m.addTrack(webrtc.MimeTypeVP8, 90000, "video", opts.VideoKbps, videoFrameInterval); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *MediaTest) addTrack(mimeType string, clockRate uint32, kind string, kbps int, interval time.Duration) error {
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: clockRate},
+		kind, "pltester",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create %s track: %w", kind, err)
+	}
+	sender, err := m.peer.AddTrack(track)
+	if err != nil {
+		return fmt.Errorf("failed to add %s track: %w", kind, err)
+	}
+
+	m.mu.Lock()
+	m.local = append(m.local, mediaStream{
+		kind:      kind,
+		ssrc:      uint32(sender.GetParameters().Encodings[0].SSRC),
+		clockRate: float64(clockRate),
+	})
+	m.mu.Unlock()
+
+	// 读取 RTCP 以便拦截器处理客户端的接收报告
+	go func() {
+		for {
+			if _, _, err := sender.ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
+
+	go m.send(track, kind, kbps, interval)
+	return nil
+}
+
+// send 以固定码率写入随机负载，未连接前写入的样本会被丢弃
+func (m *MediaTest) send(track *webrtc.TrackLocalStaticSample, kind string, kbps int, interval time.Duration) {
+	payload := make([]byte, frameBytes(kbps, interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		rand.Read(payload)
+		if err := track.WriteSample(media.Sample{Data: payload, Duration: interval}); err != nil {
+			log.Printf("Failed to write %s sample: %v", kind, err)
+			return
+		}
+	}
+}
+
+// frameBytes 按码率计算每帧负载大小，至少 1 字节
+func frameBytes(kbps int, interval time.Duration) int {
+	n := int(float64(kbps*1000/8) * interval.Seconds())
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Report 汇总当前的 RTP 流统计
+func (m *MediaTest) Report() MediaReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := MediaReport{Streams: []StreamStats{}}
+	if m.peer.rtpStats == nil {
+		return report
+	}
+	for _, s := range m.local {
+		st := m.peer.rtpStats.Get(s.ssrc)
+		if st == nil {
+			continue
+		}
+		report.Streams = append(report.Streams, StreamStats{
+			Kind:          s.kind,
+			Direction:     "send",
+			SSRC:          s.ssrc,
+			Packets:       st.OutboundRTPStreamStats.PacketsSent,
+			Bytes:         st.OutboundRTPStreamStats.BytesSent,
+			PacketsLost:   st.RemoteInboundRTPStreamStats.PacketsLost,
+			FractionLost:  st.RemoteInboundRTPStreamStats.FractionLost,
+			Jitter:        st.RemoteInboundRTPStreamStats.Jitter * 1000,
+			RoundTripTime: float64(st.RemoteInboundRTPStreamStats.RoundTripTime) / float64(time.Millisecond),
+		})
+	}
+	for _, s := range m.remote {
+		st := m.peer.rtpStats.Get(s.ssrc)
+		if st == nil {
+			continue
+		}
+		report.Streams = append(report.Streams, StreamStats{
+			Kind:        s.kind,
+			Direction:   "recv",
+			SSRC:        s.ssrc,
+			Packets:     st.InboundRTPStreamStats.PacketsReceived,
+			Bytes:       st.InboundRTPStreamStats.BytesReceived,
+			PacketsLost: st.InboundRTPStreamStats.PacketsLost,
+			Jitter:      s.jitter / s.clockRate * 1000,
+		})
+	}
+	return report
+}
+
+// Close 停止发送合成媒体流
+func (m *MediaTest) Close() {
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+}
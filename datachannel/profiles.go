@@ -0,0 +1,79 @@
+package datachannel
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// profileChannelBaseID 预协商通道的起始 ID，避开浏览器和服务端带内创建通道使用的低位 ID
+	profileChannelBaseID = 100
+	// defaultPacketLifeTime 限时重传通道的默认最大重传时间（毫秒）
+	defaultPacketLifeTime = 100
+	maxPacketLifeTime     = 10000
+
+	ProfileUnreliable = "unreliable"
+	ProfileLifetime   = "lifetime"
+	ProfileReliable   = "reliable"
+)
+
+// ReliabilityProfile 一种 DataChannel 可靠性配置，客户端须以相同的 ID 和参数创建预协商通道
+type ReliabilityProfile struct {
+	Name              string  `json:"name"`
+	ID                uint16  `json:"id"`
+	Ordered           bool    `json:"ordered"`
+	MaxRetransmits    *uint16 `json:"maxRetransmits,omitempty"`
+	MaxPacketLifeTime *uint16 `json:"maxPacketLifeTime,omitempty"` // 毫秒
+}
+
+// ReliabilityProfiles 返回用于对比测试的三种配置：不重传、限时重传（lifetimeMs，0 表示默认 100ms）和可靠有序
+func ReliabilityProfiles(lifetimeMs int) ([]ReliabilityProfile, error) {
+	if lifetimeMs < 0 || lifetimeMs > maxPacketLifeTime {
+		return nil, fmt.Errorf("packet lifetime must be between 0 and %d ms", maxPacketLifeTime)
+	}
+	if lifetimeMs == 0 {
+		lifetimeMs = defaultPacketLifeTime
+	}
+	noRetransmits := uint16(0)
+	lifetime := uint16(lifetimeMs)
+	return []ReliabilityProfile{
+		{Name: ProfileUnreliable, ID: profileChannelBaseID, MaxRetransmits: &noRetransmits},
+		{Name: ProfileLifetime, ID: profileChannelBaseID + 1, MaxPacketLifeTime: &lifetime},
+		{Name: ProfileReliable, ID: profileChannelBaseID + 2, Ordered: true},
+	}, nil
+}
+
+// IsProfileName 判断 name 是否为 ReliabilityProfiles 返回的通道名
+func IsProfileName(name string) bool {
+	switch name {
+	case ProfileUnreliable, ProfileLifetime, ProfileReliable:
+		return true
+	}
+	return false
+}
+
+// OpenProfileChannels 按配置创建预协商的 DataChannel，可在 SDP 协商前后调用，每个会话只能调用一次
+func (p *Peer) OpenProfileChannels(profiles []ReliabilityProfile) ([]*webrtc.DataChannel, error) {
+	if !p.profilesOpened.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("reliability channels already opened")
+	}
+	channels := make([]*webrtc.DataChannel, 0, len(profiles))
+	for _, profile := range profiles {
+		negotiated := true
+		id := profile.ID
+		ordered := profile.Ordered
+		d, err := p.CreateDataChannel(profile.Name, &webrtc.DataChannelInit{
+			Ordered:           &ordered,
+			MaxRetransmits:    profile.MaxRetransmits,
+			MaxPacketLifeTime: profile.MaxPacketLifeTime,
+			Negotiated:        &negotiated,
+			ID:                &id,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s channel: %w", profile.Name, err)
+		}
+		channels = append(channels, d)
+	}
+	return channels, nil
+}
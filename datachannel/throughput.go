@@ -0,0 +1,305 @@
+package datachannel
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pltester/metrics"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// 吞吐量测试使用的预协商通道 ID，位于可靠性对比通道之后
+	ThroughputBulkID = profileChannelBaseID + 10
+	ThroughputPingID = profileChannelBaseID + 11
+
+	defaultThroughputSeconds = 5
+	maxThroughputSeconds     = 60
+
+	throughputChunkSize    = 16 * 1024
+	throughputHighWater    = 1024 * 1024 // 发送缓冲达到该值时暂停写入，过大会在本端排队、抬高负载下的 RTT
+	throughputLowWater     = 256 * 1024  // 缓冲降到该值以下时继续写入
+	throughputPingInterval = 50 * time.Millisecond
+	throughputPingGrace    = 500 * time.Millisecond // 阶段结束后等待迟到回显的时间
+	throughputOpenTimeout  = 30 * time.Second
+
+	PhaseDownload = "download"
+	PhaseUpload   = "upload"
+)
+
+// ThroughputOptions 吞吐量测试参数，Seconds 为每个方向的持续时间（0 表示默认 5 秒）
+type ThroughputOptions struct {
+	Seconds int `json:"seconds"`
+}
+
+// ThroughputPhase 一个方向的测试结果。有效吞吐按接收方确认的字节计算，
+// RTT 和丢包由负载期间在不重传通道上的探测包得出。
+type ThroughputPhase struct {
+	Direction   string         `json:"direction"` // download：服务端→客户端；upload：客户端→服务端
+	Bytes       uint64         `json:"bytes"`
+	Duration    float64        `json:"duration"` // 毫秒
+	GoodputMbps float64        `json:"goodputMbps"`
+	Pings       metrics.Report `json:"pings"`
+}
+
+// ThroughputResult 吞吐量测试结果
+type ThroughputResult struct {
+	Download ThroughputPhase `json:"download"`
+	Upload   ThroughputPhase `json:"upload"`
+}
+
+// ThroughputTest 在预协商的可靠通道上依次测量下行和上行的 SCTP 吞吐
+type ThroughputTest struct {
+	duration time.Duration
+	bulk     *webrtc.DataChannel
+	ping     *webrtc.DataChannel
+	opened   sync.WaitGroup
+	low      chan struct{}
+
+	// 上行阶段统计的字节数，仅在 counting 为 true 时累加
+	counting      atomic.Bool
+	uploadedBytes atomic.Uint64
+
+	start   time.Time
+	mu      sync.Mutex
+	pings   map[uint64]*metrics.Sample
+	pingSeq uint64
+}
+
+// StartThroughput 创建吞吐量测试使用的预协商通道（可靠有序的 bulk 通道和不重传的 ping 通道），
+// 客户端须以 ThroughputBulkID / ThroughputPingID 创建对应通道，并回显 ping 通道上的消息。
+// 通道 ID 固定，每个会话只能进行一次吞吐量测试
+func (p *Peer) StartThroughput(opts ThroughputOptions) (*ThroughputTest, error) {
+	if opts.Seconds < 0 || opts.Seconds > maxThroughputSeconds {
+		return nil, fmt.Errorf("throughput duration must be between 0 and %d seconds", maxThroughputSeconds)
+	}
+	if !p.throughputStarted.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("throughput test already started")
+	}
+	if opts.Seconds == 0 {
+		opts.Seconds = defaultThroughputSeconds
+	}
+
+	t := &ThroughputTest{
+		duration: time.Duration(opts.Seconds) * time.Second,
+		low:      make(chan struct{}, 1),
+		pings:    make(map[uint64]*metrics.Sample),
+		start:    time.Now(),
+	}
+
+	negotiated := true
+	bulkID, pingID := uint16(ThroughputBulkID), uint16(ThroughputPingID)
+	unordered := false
+	noRetransmits := uint16(0)
+	bulk, err := p.CreateDataChannel("throughput", &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &bulkID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create throughput channel: %w", err)
+	}
+	ping, err := p.CreateDataChannel("throughput-ping", &webrtc.DataChannelInit{
+		Negotiated:     &negotiated,
+		ID:             &pingID,
+		Ordered:        &unordered,
+		MaxRetransmits: &noRetransmits,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create throughput ping channel: %w", err)
+	}
+	t.bulk, t.ping = bulk, ping
+
+	t.opened.Add(2)
+	bulk.OnOpen(t.opened.Done)
+	ping.OnOpen(t.opened.Done)
+
+	bulk.SetBufferedAmountLowThreshold(throughputLowWater)
+	bulk.OnBufferedAmountLow(func() {
+		select {
+		case t.low <- struct{}{}:
+		default:
+		}
+	})
+	bulk.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if t.counting.Load() {
+			t.uploadedBytes.Add(uint64(len(msg.Data)))
+		}
+	})
+	ping.OnMessage(t.onPong)
+	return t, nil
+}
+
+// Seconds 返回每个方向的实际持续时间（秒）
+func (t *ThroughputTest) Seconds() int {
+	return int(t.duration / time.Second)
+}
+
+// Run 等待通道建立后依次执行下行和上行测试。每个方向开始时调用 onPhase，
+// 由调用方通知客户端（上行阶段客户端开始发送）；done 关闭时提前结束。
+func (t *ThroughputTest) Run(done <-chan struct{}, onPhase func(phase string)) (ThroughputResult, error) {
+	opened := make(chan struct{})
+	go func() {
+		t.opened.Wait()
+		close(opened)
+	}()
+	select {
+	case <-opened:
+	case <-done:
+		return ThroughputResult{}, errors.New("connection closed before throughput channels opened")
+	case <-time.After(throughputOpenTimeout):
+		return ThroughputResult{}, errors.New("throughput channels did not open")
+	}
+
+	var result ThroughputResult
+	onPhase(PhaseDownload)
+	download, err := t.runPhase(done, t.flood)
+	if err != nil {
+		return result, err
+	}
+	download.Direction = PhaseDownload
+	result.Download = download
+
+	onPhase(PhaseUpload)
+	upload, err := t.runPhase(done, t.receive)
+	if err != nil {
+		return result, err
+	}
+	upload.Direction = PhaseUpload
+	result.Upload = upload
+	return result, nil
+}
+
+// runPhase 在 load 运行期间发送探测包，返回 load 统计的字节数和探测结果
+func (t *ThroughputTest) runPhase(done <-chan struct{}, load func(deadline time.Time, done <-chan struct{}) uint64) (ThroughputPhase, error) {
+	t.mu.Lock()
+	t.pings = make(map[uint64]*metrics.Sample)
+	t.mu.Unlock()
+
+	stopPings := make(chan struct{})
+	pingsDone := make(chan struct{})
+	go func() {
+		defer close(pingsDone)
+		t.sendPings(stopPings)
+	}()
+
+	started := time.Now()
+	bytes := load(started.Add(t.duration), done)
+	elapsed := time.Since(started)
+	close(stopPings)
+	<-pingsDone
+
+	select {
+	case <-done:
+		return ThroughputPhase{}, errors.New("connection closed during throughput test")
+	case <-time.After(throughputPingGrace):
+	}
+
+	phase := ThroughputPhase{
+		Bytes:    bytes,
+		Duration: float64(elapsed) / float64(time.Millisecond),
+		Pings:    metrics.Compute(t.pingSamples()),
+	}
+	if elapsed > 0 {
+		phase.GoodputMbps = float64(bytes) * 8 / elapsed.Seconds() / 1e6
+	}
+	return phase, nil
+}
+
+// flood 以缓冲水位控制持续写入，返回对端已确认的字节数（写入量减去仍在缓冲中的量）
+func (t *ThroughputTest) flood(deadline time.Time, done <-chan struct{}) uint64 {
+	chunk := make([]byte, throughputChunkSize)
+	rand.Read(chunk)
+
+	var written uint64
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		for t.bulk.BufferedAmount() < throughputHighWater {
+			if time.Now().After(deadline) {
+				return written - t.bulk.BufferedAmount()
+			}
+			if err := t.bulk.Send(chunk); err != nil {
+				return written - t.bulk.BufferedAmount()
+			}
+			written += throughputChunkSize
+		}
+		select {
+		case <-t.low:
+		case <-timer.C:
+			return written - t.bulk.BufferedAmount()
+		case <-done:
+			return written - t.bulk.BufferedAmount()
+		}
+	}
+}
+
+// receive 统计截止时间前客户端发来的字节数
+func (t *ThroughputTest) receive(deadline time.Time, done <-chan struct{}) uint64 {
+	t.uploadedBytes.Store(0)
+	t.counting.Store(true)
+	select {
+	case <-time.After(time.Until(deadline)):
+	case <-done:
+	}
+	t.counting.Store(false)
+	return t.uploadedBytes.Load()
+}
+
+// sendPings 定期在不重传通道上发送 "seq,毫秒时间戳"，客户端原样回显
+func (t *ThroughputTest) sendPings(stop <-chan struct{}) {
+	ticker := time.NewTicker(throughputPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		t.mu.Lock()
+		seq := t.pingSeq
+		t.pingSeq++
+		sent := t.sinceStart()
+		t.pings[seq] = &metrics.Sample{Seq: seq, Sent: sent, Lost: true}
+		t.mu.Unlock()
+		t.ping.SendText(strconv.FormatUint(seq, 10) + "," + strconv.FormatFloat(sent, 'f', 3, 64))
+	}
+}
+
+func (t *ThroughputTest) onPong(msg webrtc.DataChannelMessage) {
+	received := t.sinceStart()
+	fields := strings.SplitN(string(msg.Data), ",", 2)
+	seq, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sample, ok := t.pings[seq]; ok && sample.Lost {
+		sample.Lost = false
+		sample.Received = received
+	}
+}
+
+func (t *ThroughputTest) pingSamples() []metrics.Sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples := make([]metrics.Sample, 0, len(t.pings))
+	for _, s := range t.pings {
+		samples = append(samples, *s)
+	}
+	return samples
+}
+
+func (t *ThroughputTest) sinceStart() float64 {
+	return float64(time.Since(t.start)) / float64(time.Millisecond)
+}
+
+// Close 关闭测试通道
+func (t *ThroughputTest) Close() {
+	t.bulk.Close()
+	t.ping.Close()
+}
@@ -0,0 +1,135 @@
+package datachannel
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// 会话可请求的传输策略，用于排查时强制走某一类路径，留空表示不限制
+const (
+	TransportUDP   = "udp"   // 只用 UDP 候选，连接失败通常说明客户端网络阻断 UDP
+	TransportTCP   = "tcp"   // 只用 ICE-TCP 候选
+	TransportRelay = "relay" // 只用 TURN 中继候选
+)
+
+// turnCredentialTTL TURN REST API 临时凭据的有效期
+const turnCredentialTTL = 24 * time.Hour
+
+// TURNConfig TURN 中继服务器配置。Secret 非空时按 TURN REST API（coturn 的 use-auth-secret）
+// 为每次请求生成临时凭据，避免把长期密码下发给浏览器；否则使用固定的用户名和密码
+type TURNConfig struct {
+	URLs       []string
+	Username   string
+	Credential string
+	Secret     string
+}
+
+// Enabled 是否配置了 TURN 服务器
+func (c TURNConfig) Enabled() bool {
+	return len(c.URLs) > 0
+}
+
+// ICEServer 返回带凭据的 ICE 服务器配置
+func (c TURNConfig) ICEServer() webrtc.ICEServer {
+	server := webrtc.ICEServer{URLs: c.URLs, Username: c.Username, Credential: c.Credential}
+	if c.Secret != "" {
+		// 用户名为过期时间戳（可附加 ":用户名"），密码为 base64(HMAC-SHA1(secret, 用户名))
+		username := strconv.FormatInt(time.Now().Add(turnCredentialTTL).Unix(), 10)
+		if c.Username != "" {
+			username += ":" + c.Username
+		}
+		mac := hmac.New(sha1.New, []byte(c.Secret))
+		mac.Write([]byte(username))
+		server.Username = username
+		server.Credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return server
+}
+
+// ValidateTransport 检查传输策略是否可用
+func ValidateTransport(transport string, turn TURNConfig) error {
+	switch transport {
+	case "", TransportUDP, TransportTCP:
+		return nil
+	case TransportRelay:
+		if !turn.Enabled() {
+			return fmt.Errorf("relay transport requires a TURN server")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown transport %q", transport)
+	}
+}
+
+// filterNetworkTypes 按传输策略保留 UDP 或 TCP 网络类型
+func filterNetworkTypes(types []webrtc.NetworkType, transport string) []webrtc.NetworkType {
+	if transport != TransportUDP && transport != TransportTCP {
+		return types
+	}
+	filtered := make([]webrtc.NetworkType, 0, len(types))
+	for _, t := range types {
+		if t.Protocol() == transport {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// iceTCPReadBuffer ICE-TCP 连接建立前缓存的包数
+const iceTCPReadBuffer = 8
+
+// ListenICETCP 在固定端口监听 ICE-TCP，所有会话共用这一个端口，
+// 防火墙只需放行它，UDP 受限的客户端也能通过 TCP 完成 DataChannel 测试
+func ListenICETCP(port int) (ice.TCPMux, error) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for ICE-TCP: %w", err)
+	}
+	return webrtc.NewICETCPMux(nil, listener, iceTCPReadBuffer), nil
+}
+
+// Path 当前选中的候选对，说明连接实际走的路径
+type Path struct {
+	Protocol   string `json:"protocol"`
+	LocalType  string `json:"localType"`
+	RemoteType string `json:"remoteType"`
+	Local      string `json:"local"`
+	Remote     string `json:"remote"`
+}
+
+// SelectedPath 返回 ICE 选中的候选对，尚未连接时返回 nil
+func (p *Peer) SelectedPath() *Path {
+	sctp := p.SCTP()
+	if sctp == nil || sctp.Transport() == nil || sctp.Transport().ICETransport() == nil {
+		return nil
+	}
+	pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return nil
+	}
+	return &Path{
+		Protocol:   pair.Local.Protocol.String(),
+		LocalType:  pair.Local.Typ.String(),
+		RemoteType: pair.Remote.Typ.String(),
+		Local:      net.JoinHostPort(pair.Local.Address, strconv.Itoa(int(pair.Local.Port))),
+		Remote:     net.JoinHostPort(pair.Remote.Address, strconv.Itoa(int(pair.Remote.Port))),
+	}
+}
+
+// BytesReceived 返回 SCTP 关联收到的应用数据字节数（全部数据通道合计），
+// 不含 ICE 连通性检查，可用于判断会话是否空闲
+func (p *Peer) BytesReceived() uint64 {
+	stats, ok := p.GetStats()["sctpTransport"].(webrtc.SCTPTransportStats)
+	if !ok {
+		return 0
+	}
+	return stats.BytesReceived
+}
@@ -0,0 +1,172 @@
+// Package impair emulates a degraded network path inside the server: random
+// and Gilbert-Elliott burst loss, fixed delay, jitter, reordering and a rate
+// limit. Because the server knows exactly which packets it dropped or held
+// back, a test run against an impaired path has a known ground truth that
+// client-side loss and latency figures can be checked against.
+package impair
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	maxDelayMs = 10000
+	// defaultReorderDelayMs is how long a reordered packet is held back when
+	// ReorderDelayMs is zero.
+	defaultReorderDelayMs = 20
+)
+
+// Config describes the impairments applied to a path. The zero value leaves
+// the path untouched.
+type Config struct {
+	// LossRatio is the probability that a packet is dropped independently of
+	// every other packet.
+	LossRatio float64 `json:"lossRatio,omitempty"`
+	// Burst adds correlated loss on top of LossRatio.
+	Burst *GilbertElliott `json:"burst,omitempty"`
+
+	// DelayMs is added to every packet; JitterMs varies it uniformly within
+	// ±JitterMs. Jitter alone never reorders packets: a packet whose delay
+	// would let it overtake an earlier one is held until that one is delivered.
+	DelayMs  float64 `json:"delayMs,omitempty"`
+	JitterMs float64 `json:"jitterMs,omitempty"`
+
+	// ReorderRatio is the probability that a packet is held back an extra
+	// ReorderDelayMs (default 20 ms) so that later packets overtake it.
+	ReorderRatio   float64 `json:"reorderRatio,omitempty"`
+	ReorderDelayMs float64 `json:"reorderDelayMs,omitempty"`
+
+	// RateKbps limits the path to this bit rate. Packets queue behind the
+	// limit and are dropped once the queue would hold them for over a second.
+	RateKbps int `json:"rateKbps,omitempty"`
+
+	// Seed makes the random decisions reproducible; zero seeds from the clock.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// GilbertElliott is the two-state burst loss model. The path moves from the
+// good to the bad state with probability P and back with probability R,
+// evaluated once per packet, and loses packets with probability LossGood or
+// LossBad depending on the state. LossBad of zero means 1, the classic
+// Gilbert model in which every packet in the bad state is lost; its mean
+// loss ratio is P/(P+R) and the mean burst length 1/R packets.
+type GilbertElliott struct {
+	P        float64 `json:"p"`
+	R        float64 `json:"r"`
+	LossGood float64 `json:"lossGood,omitempty"`
+	LossBad  float64 `json:"lossBad,omitempty"`
+}
+
+func (g *GilbertElliott) lossBad() float64 {
+	if g.LossBad == 0 {
+		return 1
+	}
+	return g.LossBad
+}
+
+// Enabled reports whether the configuration impairs the path at all.
+func (c Config) Enabled() bool {
+	return c.LossRatio > 0 || c.Burst != nil || c.DelayMs > 0 || c.JitterMs > 0 ||
+		c.ReorderRatio > 0 || c.RateKbps > 0
+}
+
+// Validate checks that every parameter is within range.
+func (c Config) Validate() error {
+	if err := checkRatio("lossRatio", c.LossRatio); err != nil {
+		return err
+	}
+	if err := checkRatio("reorderRatio", c.ReorderRatio); err != nil {
+		return err
+	}
+	if g := c.Burst; g != nil {
+		if g.P <= 0 || g.P > 1 || g.R <= 0 || g.R > 1 {
+			return errors.New("burst p and r must be in (0, 1]")
+		}
+		if err := checkRatio("burst lossGood", g.LossGood); err != nil {
+			return err
+		}
+		if err := checkRatio("burst lossBad", g.LossBad); err != nil {
+			return err
+		}
+	}
+	for _, ms := range []struct {
+		name  string
+		value float64
+	}{{"delayMs", c.DelayMs}, {"jitterMs", c.JitterMs}, {"reorderDelayMs", c.ReorderDelayMs}} {
+		if ms.value < 0 || ms.value > maxDelayMs {
+			return fmt.Errorf("%s must be between 0 and %d", ms.name, maxDelayMs)
+		}
+	}
+	if c.RateKbps < 0 {
+		return errors.New("rateKbps must not be negative")
+	}
+	return nil
+}
+
+// String describes the impairments in a single line for logs.
+func (c Config) String() string {
+	var parts []string
+	if c.LossRatio > 0 {
+		parts = append(parts, fmt.Sprintf("loss %g%%", c.LossRatio*100))
+	}
+	if g := c.Burst; g != nil {
+		parts = append(parts, fmt.Sprintf("burst p=%g r=%g loss %g/%g", g.P, g.R, g.LossGood, g.lossBad()))
+	}
+	if c.DelayMs > 0 || c.JitterMs > 0 {
+		parts = append(parts, fmt.Sprintf("delay %gms ±%gms", c.DelayMs, c.JitterMs))
+	}
+	if c.ReorderRatio > 0 {
+		parts = append(parts, fmt.Sprintf("reorder %g%% by %v", c.ReorderRatio*100, c.reorderDelay()))
+	}
+	if c.RateKbps > 0 {
+		parts = append(parts, fmt.Sprintf("rate %d kbps", c.RateKbps))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func checkRatio(name string, v float64) error {
+	if v < 0 || v > 1 {
+		return fmt.Errorf("%s must be between 0 and 1", name)
+	}
+	return nil
+}
+
+// Latency draws one packet delay from DelayMs and JitterMs, never negative.
+// A nil rng uses the shared, goroutine-safe source.
+func (c Config) Latency(rng *rand.Rand) time.Duration {
+	ms := c.DelayMs
+	if c.JitterMs > 0 {
+		u := rand.Float64
+		if rng != nil {
+			u = rng.Float64
+		}
+		ms += (u()*2 - 1) * c.JitterMs
+	}
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func (c Config) reorderDelay() time.Duration {
+	ms := c.ReorderDelayMs
+	if ms == 0 {
+		ms = defaultReorderDelayMs
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func (c Config) newRand() *rand.Rand {
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,263 @@
+package impair
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxQueueDelay bounds the rate limiter's queue; packets that would wait
+// longer are dropped as a bottleneck router would.
+const maxQueueDelay = time.Second
+
+// Stats counts what a Link did to the packets passed through it.
+type Stats struct {
+	Packets      uint64  `json:"packets"`
+	Dropped      uint64  `json:"dropped"`      // random and burst loss
+	QueueDropped uint64  `json:"queueDropped"` // rate limiter overflow
+	Reordered    uint64  `json:"reordered"`
+	LossRatio    float64 `json:"lossRatio"` // (dropped + queueDropped) / packets
+}
+
+// Link applies a Config to a stream of packets in one direction. Delayed
+// packets are delivered one at a time on the link's own goroutine, in
+// delivery time order, so a caller that sends from the callbacks keeps the
+// order the link decided. Packets that need no delay are delivered by Send.
+type Link struct {
+	cfg Config
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	bad   bool      // Gilbert-Elliott state
+	last  time.Time // delivery time of the latest in-order packet
+	busy  time.Time // when the rate limiter has sent everything queued
+	stats Stats
+	queue deliveryQueue
+	seq   uint64
+	// delivering is set while run calls callbacks it has taken off the
+	// queue, so that Send does not deliver a later packet ahead of them.
+	delivering bool
+	closed     bool
+
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewLink starts a link for cfg, or returns nil if cfg impairs nothing. A nil
+// *Link is valid and delivers every packet immediately.
+func NewLink(cfg Config) *Link {
+	if !cfg.Enabled() {
+		return nil
+	}
+	l := &Link{
+		cfg:  cfg,
+		rng:  cfg.newRand(),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Config returns the configuration the link was created with.
+func (l *Link) Config() Config {
+	if l == nil {
+		return Config{}
+	}
+	return l.cfg
+}
+
+// Send passes a packet of size bytes through the link. It returns false if
+// the packet was dropped; otherwise deliver is called once the packet's
+// emulated delay has passed, possibly before Send returns.
+func (l *Link) Send(size int, deliver func()) bool {
+	if l == nil {
+		deliver()
+		return true
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return false
+	}
+	l.stats.Packets++
+	if l.lose() {
+		l.stats.Dropped++
+		l.mu.Unlock()
+		return false
+	}
+
+	now := time.Now()
+	at := now
+	if l.cfg.RateKbps > 0 {
+		if l.busy.After(at) {
+			at = l.busy
+		}
+		if at.Sub(now) > maxQueueDelay {
+			l.stats.QueueDropped++
+			l.mu.Unlock()
+			return false
+		}
+		at = at.Add(time.Duration(float64(size*8) / float64(l.cfg.RateKbps) * float64(time.Millisecond)))
+		l.busy = at
+	}
+	at = at.Add(l.cfg.Latency(l.rng))
+	if l.cfg.ReorderRatio > 0 && l.rng.Float64() < l.cfg.ReorderRatio {
+		at = at.Add(l.cfg.reorderDelay())
+		l.stats.Reordered++
+	} else {
+		if at.Before(l.last) {
+			at = l.last
+		}
+		l.last = at
+	}
+
+	if !at.After(now) && l.queue.Len() == 0 && !l.delivering {
+		l.mu.Unlock()
+		deliver()
+		return true
+	}
+	l.seq++
+	heap.Push(&l.queue, delivery{at: at, seq: l.seq, deliver: deliver})
+	l.mu.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// lose decides whether the next packet is lost; l.mu must be held.
+func (l *Link) lose() bool {
+	if g := l.cfg.Burst; g != nil {
+		if l.bad {
+			l.bad = l.rng.Float64() >= g.R
+		} else {
+			l.bad = l.rng.Float64() < g.P
+		}
+		p := g.LossGood
+		if l.bad {
+			p = g.lossBad()
+		}
+		if p > 0 && l.rng.Float64() < p {
+			return true
+		}
+	}
+	return l.cfg.LossRatio > 0 && l.rng.Float64() < l.cfg.LossRatio
+}
+
+// Stats returns the counters so far.
+func (l *Link) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.stats
+	if s.Packets > 0 {
+		s.LossRatio = float64(s.Dropped+s.QueueDropped) / float64(s.Packets)
+	}
+	return s
+}
+
+// Close stops the link; packets still in flight and any sent afterwards are
+// discarded.
+func (l *Link) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+func (l *Link) run() {
+	var due []func()
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		for l.queue.Len() > 0 && !l.queue[0].at.After(now) {
+			due = append(due, heap.Pop(&l.queue).(delivery).deliver)
+		}
+		l.delivering = len(due) > 0
+		l.mu.Unlock()
+
+		if len(due) > 0 {
+			for i, deliver := range due {
+				deliver()
+				due[i] = nil
+			}
+			due = due[:0]
+			l.mu.Lock()
+			l.delivering = false
+			l.mu.Unlock()
+			// more packets may have become due while delivering
+			continue
+		}
+
+		l.mu.Lock()
+		wait := time.Duration(-1)
+		if l.queue.Len() > 0 {
+			wait = l.queue[0].at.Sub(time.Now())
+		}
+		l.mu.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		select {
+		case <-l.wake:
+		case <-fire:
+		case <-l.done:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+type delivery struct {
+	at      time.Time
+	seq     uint64 // breaks ties so equal times keep send order
+	deliver func()
+}
+
+type deliveryQueue []delivery
+
+func (q deliveryQueue) Len() int { return len(q) }
+func (q deliveryQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q deliveryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *deliveryQueue) Push(x any)   { *q = append(*q, x.(delivery)) }
+func (q *deliveryQueue) Pop() any {
+	old := *q
+	d := old[len(old)-1]
+	old[len(old)-1] = delivery{}
+	*q = old[:len(old)-1]
+	return d
+}
+
+// Report is a link's configuration together with what it did, the ground
+// truth a client's measurements can be compared with.
+type Report struct {
+	Config Config `json:"config"`
+	Stats  Stats  `json:"stats"`
+}
+
+// Report returns the link's configuration and current counters.
+func (l *Link) Report() Report {
+	return Report{Config: l.Config(), Stats: l.Stats()}
+}
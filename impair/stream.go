@@ -0,0 +1,109 @@
+package impair
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// paceInterval is the granularity at which streams are rate limited.
+const paceInterval = 20 * time.Millisecond
+
+// pacer spaces out byte counts so they average rateKbps.
+type pacer struct {
+	bytesPerSecond float64
+	start          time.Time
+	total          int64
+}
+
+func newPacer(rateKbps int) *pacer {
+	return &pacer{bytesPerSecond: float64(rateKbps) * 1000 / 8, start: time.Now()}
+}
+
+// chunk is the most a single write should carry to keep pacing smooth.
+func (p *pacer) chunk() int {
+	n := int(p.bytesPerSecond * paceInterval.Seconds())
+	if n < 1500 {
+		n = 1500
+	}
+	return n
+}
+
+// wait sleeps until n more bytes are within the rate.
+func (p *pacer) wait(n int) {
+	p.total += int64(n)
+	due := p.start.Add(time.Duration(float64(p.total) / p.bytesPerSecond * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// NewWriter returns w limited to cfg.RateKbps, flushing after every paced
+// chunk when w is an http.Flusher. Loss and reordering do not apply to a
+// byte stream. w is returned unchanged when there is no rate limit.
+func NewWriter(w io.Writer, cfg Config) io.Writer {
+	if cfg.RateKbps <= 0 {
+		return w
+	}
+	flusher, _ := w.(http.Flusher)
+	return &pacedWriter{w: w, flusher: flusher, pacer: newPacer(cfg.RateKbps)}
+}
+
+type pacedWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	pacer   *pacer
+}
+
+func (pw *pacedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := pw.pacer.chunk()
+		if n > len(b) {
+			n = len(b)
+		}
+		pw.pacer.wait(n)
+		m, err := pw.w.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		if pw.flusher != nil {
+			pw.flusher.Flush()
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// NewReader returns r limited to cfg.RateKbps. Once the server stops reading,
+// TCP flow control slows the sender to the same rate.
+func NewReader(r io.Reader, cfg Config) io.Reader {
+	if cfg.RateKbps <= 0 {
+		return r
+	}
+	return &pacedReader{r: r, pacer: newPacer(cfg.RateKbps)}
+}
+
+type pacedReader struct {
+	r     io.Reader
+	pacer *pacer
+}
+
+func (pr *pacedReader) Read(b []byte) (int, error) {
+	if n := pr.pacer.chunk(); len(b) > n {
+		b = b[:n]
+	}
+	n, err := pr.r.Read(b)
+	pr.pacer.wait(n)
+	return n, err
+}
+
+// Pause sleeps for one draw of the configured delay and jitter, emulating
+// path latency for request/response exchanges.
+func Pause(cfg Config) {
+	if cfg.DelayMs <= 0 && cfg.JitterMs <= 0 {
+		return
+	}
+	time.Sleep(cfg.Latency(nil))
+}
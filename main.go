@@ -1,24 +1,45 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"pltester/certs"
 	"pltester/config"
+	"pltester/datachannel"
 	"pltester/ipinfo"
+	"pltester/nat"
+	"pltester/session"
 	"pltester/speedtest"
+	"pltester/stamp"
 	"pltester/ws"
 
-	"golang.org/x/net/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
 )
 
 //go:embed static
 var staticFS embed.FS
 
+// defaultDrainPeriod 关闭时等待进行中测试结束的默认时长
+const defaultDrainPeriod = 30 * time.Second
+
+// selfSignedDir 自签名模式下本地 CA 和证书的保存目录
+const selfSignedDir = "etc/tls"
+
+// defaultSessionArchive 未配置 session_archive 时关闭前写入会话结果的文件，位于挂载的配置目录中，重新部署后仍保留
+const defaultSessionArchive = "etc/sessions.jsonl"
+
 func getLocalIP() string {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -56,15 +77,106 @@ func main() {
 		log.Println("No UDP port range configured, using random ports")
 	}
 
+	// 启动 STAMP/TWAMP-Light 反射器（如果配置了）
+	if cfg.STAMPPort > 0 {
+		reflector, err := stamp.Listen(fmt.Sprintf(":%d", cfg.STAMPPort))
+		if err != nil {
+			log.Fatalf("Failed to start STAMP reflector: %v", err)
+		}
+		log.Printf("STAMP/TWAMP-Light reflector listening on UDP %s", reflector.Addr())
+		go func() {
+			if err := reflector.Serve(); err != nil {
+				log.Printf("STAMP reflector stopped: %v", err)
+			}
+		}()
+	}
+
+	// 启动内置 STUN 服务器（默认使用标准端口，stun_port 为负数时不启用），支持 RFC 5780 NAT 行为检测
+	stunPort := cfg.STUNPort
+	if stunPort == 0 {
+		stunPort = nat.DefaultPort
+	}
+	if stunPort > 0 {
+		stunServer, err := nat.Listen(nat.ServerOptions{
+			Port:     stunPort,
+			AltPort:  cfg.STUNAltPort,
+			IP:       cfg.STUNIP,
+			AltIP:    cfg.STUNAltIP,
+			PublicIP: cfg.PublicIP,
+		})
+		switch {
+		case err != nil && cfg.STUNPort == 0:
+			// 默认端口可能已被本机其他 STUN/TURN 服务占用，此时退回公共 STUN 服务器
+			log.Printf("Warning: Failed to start STUN server on default port %d, NAT detection disabled: %v", stunPort, err)
+		case err != nil:
+			log.Fatalf("Failed to start STUN server: %v", err)
+		default:
+			log.Printf("STUN server listening on UDP %s (alternate address: %t)", stunServer.Addr(), stunServer.HasAltIP())
+			go func() {
+				if err := stunServer.Serve(); err != nil {
+					log.Printf("STUN server stopped: %v", err)
+				}
+			}()
+			ws.SetSTUNServer(stunServer)
+		}
+	}
+
+	// ICE-TCP 固定端口（如果配置了），UDP 受限的客户端可经 TCP 连接，防火墙只需放行这一个端口
+	if cfg.ICETCPPort > 0 {
+		tcpMux, err := datachannel.ListenICETCP(cfg.ICETCPPort)
+		if err != nil {
+			log.Fatalf("Failed to start ICE-TCP listener: %v", err)
+		}
+		log.Printf("ICE-TCP listening on TCP port %d", cfg.ICETCPPort)
+		ws.SetICETCPMux(tcpMux)
+	}
+
+	// TURN 中继服务器（如果配置了），用于仅中继传输策略
+	if len(cfg.TURNURLs) > 0 {
+		ws.SetTURNServer(datachannel.TURNConfig{
+			URLs:       cfg.TURNURLs,
+			Username:   cfg.TURNUsername,
+			Credential: cfg.TURNCredential,
+			Secret:     cfg.TURNSecret,
+		})
+		log.Printf("TURN relay configured: %v", cfg.TURNURLs)
+	}
+
+	// 模拟网络损伤（如果配置了），用于验证客户端的丢包/时延计算
+	if cfg.Impairment != nil && cfg.Impairment.Enabled() {
+		if err := cfg.Impairment.Validate(); err != nil {
+			log.Fatalf("Invalid impairment configuration: %v", err)
+		}
+		ws.SetImpairment(*cfg.Impairment)
+		speedtest.SetImpairment(*cfg.Impairment)
+		log.Printf("Network impairment enabled: %s", cfg.Impairment)
+	}
+
+	// 会话记录（时间序列等结果保存在服务端）
+	sessions := session.NewStore(session.Options{
+		Retention:       time.Duration(cfg.SessionRetentionMinutes) * time.Minute,
+		BucketWidth:     time.Duration(cfg.TimeSeriesBucketSeconds) * time.Second,
+		OutageThreshold: time.Duration(cfg.OutageThresholdMs) * time.Millisecond,
+	})
+	ws.SetSessionStore(sessions)
+	ws.SetResumeGrace(time.Duration(cfg.ResumeGraceSeconds) * time.Second)
+	ws.SetSessionTimeouts(time.Duration(cfg.NegotiationTimeoutSeconds)*time.Second, time.Duration(cfg.IdleTimeoutSeconds)*time.Second)
+
 	// 添加CORS中间件
 	ipService := ipinfo.NewService(cfg.PublicIP, cfg.IPAPICustomHost)
 
 	mux := http.NewServeMux()
-	mux.Handle("/ws", websocket.Handler(ws.WebSocketHandler))
+	mux.Handle("/ws", ws.KeepaliveHandler(ws.WebSocketHandler))
+	mux.Handle("/ws/probe", ws.KeepaliveHandler(ws.ProbeHandler))
+	mux.HandleFunc("/api/echo/stats", ws.EchoStatsHandler)
+	mux.HandleFunc("/api/ice", ws.ICEServersHandler)
 	mux.HandleFunc("/speedtest/download", speedtest.DownloadHandler)
 	mux.HandleFunc("/speedtest/upload", speedtest.UploadHandler)
 	mux.HandleFunc("/speedtest/ping", speedtest.PingHandler)
 	mux.HandleFunc("/api/ipinfo", ipService.Handler())
+	mux.HandleFunc("/api/sessions/", sessions.Handler())
+	mux.HandleFunc("/api/outages", sessions.OutagesHandler())
+	mux.HandleFunc("/api/compare", sessions.CompareHandler())
 
 	// 使用嵌入的文件系统
 	staticSub, err := fs.Sub(staticFS, "static")
@@ -74,20 +186,146 @@ func main() {
 	fs := http.FileServer(http.FS(staticSub))
 	mux.Handle("/", fs)
 
+	// HTTPS：浏览器只在安全上下文中提供完整的 WebRTC 和 performance API，
+	// 配置证书文件或启用自签名后无需在前面再放一层反向代理
+	certFile, keyFile := cfg.TLSCertFile, cfg.TLSKeyFile
+	if (certFile == "") != (keyFile == "") {
+		log.Fatal("tls_cert_file and tls_key_file must be set together")
+	}
+	if certFile == "" && cfg.TLSSelfSigned {
+		hostname, _ := os.Hostname()
+		files, err := certs.EnsureSelfSigned(selfSignedDir, []string{"localhost", "127.0.0.1", "::1", hostname, getLocalIP(), cfg.PublicIP})
+		if err != nil {
+			log.Fatalf("Failed to create self-signed certificate: %v", err)
+		}
+		certFile, keyFile = files.CertFile, files.KeyFile
+		log.Printf("Using self-signed certificate, install %s as a trusted root on test devices (also served at /tls/ca.pem)", files.CAFile)
+		mux.HandleFunc("/tls/ca.pem", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+			http.ServeFile(w, r, files.CAFile)
+		})
+	}
+	var tlsCerts *certs.Reloader
+	if certFile != "" {
+		tlsCerts, err = certs.NewReloader(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+	}
+
 	// 包装CORS中间件
 	handler := corsMiddleware(mux)
 
+	scheme := "http"
+	if tlsCerts != nil {
+		scheme = "https"
+	}
 	fmt.Printf("Server started at :%d\n", cfg.ListenPort)
-	log.Println("Open http://localhost:" + fmt.Sprint(cfg.ListenPort) + " in your browser")
-	log.Println("LAN Address: http://" + getLocalIP() + ":" + fmt.Sprint(cfg.ListenPort))
+	log.Printf("Open %s://localhost:%d in your browser", scheme, cfg.ListenPort)
+	log.Printf("LAN Address: %s://%s:%d", scheme, getLocalIP(), cfg.ListenPort)
 	log.Println("Press Ctrl+C to stop the server")
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.ListenPort), handler))
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.ListenPort), Handler: handler}
+	var redirect *http.Server
+	var wt *webtransport.Server
+	var quicListener *quic.EarlyListener
+	reloadStop := make(chan struct{})
+	if cfg.HTTP3 && tlsCerts == nil {
+		log.Println("HTTP/3 requires HTTPS, set tls_cert_file/tls_key_file or tls_self_signed")
+	}
+	if tlsCerts != nil {
+		server.TLSConfig = tlsCerts.TLSConfig()
+		// HTTP/3：同一端口号的 UDP 上提供相同的页面和测速接口，TCP 响应通过 Alt-Svc 通告，
+		// 浏览器此后的请求改走 QUIC。同一端口还接受 WebTransport 和原生 QUIC 的数据报探测
+		if cfg.HTTP3 {
+			// 与 CORS 配置一致，允许其他节点的页面连接
+			wt = &webtransport.Server{H3: http3.Server{Handler: handler}, CheckOrigin: func(*http.Request) bool { return true }}
+			mux.Handle("/webtransport", ws.WebTransportHandler(wt))
+
+			tlsConfig := tlsCerts.TLSConfig()
+			tlsConfig.NextProtos = []string{http3.NextProtoH3, ws.QUICProbeALPN}
+			quicListener, err = quic.ListenAddrEarly(server.Addr, tlsConfig, &quic.Config{EnableDatagrams: true})
+			if err != nil {
+				log.Fatalf("Failed to start HTTP/3 listener: %v", err)
+			}
+			server.Handler = altSvcMiddleware(cfg.ListenPort, handler)
+			log.Printf("HTTP/3, WebTransport and QUIC probes listening on UDP port %d", cfg.ListenPort)
+			go serveQUIC(quicListener, wt)
+		}
+		go tlsCerts.Watch(certs.DefaultReloadInterval, reloadStop)
+		go func() {
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		if cfg.HTTPRedirectPort > 0 {
+			redirect = &http.Server{Addr: fmt.Sprintf(":%d", cfg.HTTPRedirectPort), Handler: certs.RedirectHandler(cfg.ListenPort)}
+			log.Printf("Redirecting HTTP on :%d to HTTPS", cfg.HTTPRedirectPort)
+			go func() {
+				if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
+			}()
+		}
+	} else {
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// 收到 SIGINT/SIGTERM 后排空会话再退出，部署重启时进行中的测试可以完成并保留结果；
+	// 排空期间再次按 Ctrl+C 立即退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	drain := time.Duration(cfg.ShutdownDrainSeconds) * time.Second
+	if drain <= 0 {
+		drain = defaultDrainPeriod
+	}
+	log.Printf("Shutting down, draining sessions for up to %s", drain)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	ws.Drain(drainCtx)
+	cancel()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+	if quicListener != nil {
+		quicListener.Close()
+		wt.Close()
+	}
+	close(reloadStop)
+
+	sessions.FinishAll()
+	// session_archive 为 "-" 时不保存
+	archive := cfg.SessionArchive
+	if archive == "" {
+		archive = defaultSessionArchive
+	}
+	if archive != "-" {
+		n, err := sessions.AppendArchive(archive)
+		if err != nil {
+			log.Printf("Failed to archive sessions: %v", err)
+		} else {
+			log.Printf("Archived %d sessions to %s", n, archive)
+		}
+	}
+	log.Println("Server stopped")
 }
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", speedtest.ProtocolHeader)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -97,3 +335,32 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// altSvcMiddleware 在 TCP 响应中添加 Alt-Svc 头，通告同端口的 HTTP/3 服务
+func altSvcMiddleware(port int, next http.Handler) http.Handler {
+	altSvc := fmt.Sprintf(`%s=":%d"; ma=2592000`, http3.NextProtoH3, port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveQUIC 接受 UDP 端口上的 QUIC 连接并按 ALPN 分发：h3 交给 HTTP/3 和 WebTransport，
+// ws.QUICProbeALPN 交给原生 QUIC 探测
+func serveQUIC(ln *quic.EarlyListener, wt *webtransport.Server) {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		if conn.ConnectionState().TLS.NegotiatedProtocol == ws.QUICProbeALPN {
+			go ws.ServeQUICProbe(conn)
+			continue
+		}
+		go func() {
+			if err := wt.ServeQUICConn(conn); err != nil {
+				log.Printf("HTTP/3 connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
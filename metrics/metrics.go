@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// jitterGain is the 1/16 smoothing factor from RFC 3550 section 6.4.1.
+const jitterGain = 1.0 / 16.0
+
+// maxNReordering bounds the n-reordering histogram reported to clients.
+const maxNReordering = 8
+
+// Sample is a single probe as observed by the measuring endpoint. Sent and
+// Received are milliseconds on the sender's and receiver's clocks. Only
+// differences are used, so the clocks need not be synchronised for the
+// variation and reordering metrics.
+type Sample struct {
+	Seq      uint64  `json:"seq"`
+	Sent     float64 `json:"sent"`
+	Received float64 `json:"received"`
+	Lost     bool    `json:"lost"`
+}
+
+// Delay returns the transit time of a received sample.
+func (s Sample) Delay() float64 {
+	return s.Received - s.Sent
+}
+
+// Report is the full set of results for one measurement run.
+type Report struct {
+	Sent       int           `json:"sent"`
+	Received   int           `json:"received"`
+	Lost       int           `json:"lost"`
+	Duplicates int           `json:"duplicates"`
+	LossRatio  float64       `json:"lossRatio"`
+	Latency    Distribution  `json:"latency"`
+	Jitter     float64       `json:"jitter"`
+	IPDV       IPDVStats     `json:"ipdv"`
+	PDV        Distribution  `json:"pdv"`
+	Reordering ReorderReport `json:"reordering"`
+}
+
+// Distribution summarises a set of values in milliseconds.
+type Distribution struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// IPDVStats reports RFC 5481 inter-packet delay variation between packets
+// with consecutive sequence numbers.
+type IPDVStats struct {
+	Distribution
+	MeanAbs float64 `json:"meanAbs"`
+}
+
+// ReorderReport reports RFC 4737 reordering metrics.
+type ReorderReport struct {
+	Reordered  int     `json:"reordered"`
+	Ratio      float64 `json:"ratio"`
+	MaxExtent  int     `json:"maxExtent"`
+	MeanExtent float64 `json:"meanExtent"`
+	// NReordering[n-1] counts packets that are n-reordered (RFC 4737 section 5).
+	NReordering []int `json:"nReordering"`
+}
+
+// Compute derives every metric from a set of samples. Samples may arrive in
+// any order; duplicates of an already received sequence number are counted
+// and otherwise ignored.
+func Compute(samples []Sample) Report {
+	bySeq := dedupe(samples)
+
+	var report Report
+	report.Sent = len(bySeq)
+	report.Duplicates = len(samples) - len(bySeq)
+
+	received := make([]Sample, 0, len(bySeq))
+	for _, s := range bySeq {
+		if !s.Lost {
+			received = append(received, s)
+		}
+	}
+	report.Received = len(received)
+	report.Lost = report.Sent - report.Received
+	if report.Sent > 0 {
+		report.LossRatio = float64(report.Lost) / float64(report.Sent)
+	}
+
+	delays := make([]float64, len(received))
+	for i, s := range received {
+		delays[i] = s.Delay()
+	}
+	report.Latency = Summarize(delays)
+	report.PDV = PDV(delays)
+	report.IPDV = IPDV(bySeq)
+
+	arrivals := ArrivalOrder(received)
+	report.Jitter = InterarrivalJitter(arrivals)
+	report.Reordering = Reordering(arrivals)
+	return report
+}
+
+// dedupe returns samples sorted by sequence number with at most one entry per
+// sequence number, preferring a received copy over a lost one.
+func dedupe(samples []Sample) []Sample {
+	sorted := make([]Sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Seq < sorted[j].Seq
+	})
+
+	out := sorted[:0]
+	for _, s := range sorted {
+		if n := len(out); n > 0 && out[n-1].Seq == s.Seq {
+			if out[n-1].Lost && !s.Lost {
+				out[n-1] = s
+			}
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// ArrivalOrder returns received samples sorted by receive time, breaking ties
+// by sequence number.
+func ArrivalOrder(received []Sample) []Sample {
+	arrivals := make([]Sample, 0, len(received))
+	for _, s := range received {
+		if !s.Lost {
+			arrivals = append(arrivals, s)
+		}
+	}
+	sort.SliceStable(arrivals, func(i, j int) bool {
+		if arrivals[i].Received != arrivals[j].Received {
+			return arrivals[i].Received < arrivals[j].Received
+		}
+		return arrivals[i].Seq < arrivals[j].Seq
+	})
+	return arrivals
+}
+
+// InterarrivalJitter computes the RFC 3550 interarrival jitter estimate over
+// samples given in arrival order and returns the final value of J.
+func InterarrivalJitter(arrivals []Sample) float64 {
+	var jitter float64
+	for i := 1; i < len(arrivals); i++ {
+		d := arrivals[i].Delay() - arrivals[i-1].Delay()
+		jitter += (math.Abs(d) - jitter) * jitterGain
+	}
+	return jitter
+}
+
+// IPDV computes RFC 5481 inter-packet delay variation for every pair of
+// received packets with consecutive sequence numbers. bySeq must be sorted
+// by sequence number.
+func IPDV(bySeq []Sample) IPDVStats {
+	var values []float64
+	var absSum float64
+	for i := 1; i < len(bySeq); i++ {
+		prev, cur := bySeq[i-1], bySeq[i]
+		if prev.Lost || cur.Lost || cur.Seq != prev.Seq+1 {
+			continue
+		}
+		v := cur.Delay() - prev.Delay()
+		values = append(values, v)
+		absSum += math.Abs(v)
+	}
+
+	stats := IPDVStats{Distribution: Summarize(values)}
+	if len(values) > 0 {
+		stats.MeanAbs = absSum / float64(len(values))
+	}
+	return stats
+}
+
+// PDV computes RFC 5481 packet delay variation, the delay of each packet
+// relative to the minimum delay observed in the run.
+func PDV(delays []float64) Distribution {
+	if len(delays) == 0 {
+		return Distribution{}
+	}
+	minDelay := delays[0]
+	for _, d := range delays[1:] {
+		if d < minDelay {
+			minDelay = d
+		}
+	}
+	values := make([]float64, len(delays))
+	for i, d := range delays {
+		values[i] = d - minDelay
+	}
+	return Summarize(values)
+}
+
+// Reordering computes RFC 4737 reordering metrics over samples given in
+// arrival order.
+func Reordering(arrivals []Sample) ReorderReport {
+	var r reorderState
+	for _, s := range arrivals {
+		r.observe(s.Seq)
+	}
+	return r.report()
+}
+
+// maxReorderRecords bounds the arrivals kept for the extent search. Extents
+// reaching further back than this many in-order arrivals are understated.
+const maxReorderRecords = 1 << 20
+
+// reorderRecord is an arrival that raised the highest sequence number seen.
+type reorderRecord struct {
+	seq   uint64
+	index int
+}
+
+// reorderState applies the RFC 4737 definitions one arrival at a time.
+type reorderState struct {
+	arrivals int
+	nextExp  uint64
+
+	// records holds the arrivals that raised the highest sequence number
+	// seen so far. Their sequence numbers increase, so the earliest arrival
+	// with a larger sequence number is found by binary search.
+	records []reorderRecord
+	// recent holds the sequence numbers of the last maxNReordering
+	// arrivals, indexed by arrival index modulo maxNReordering.
+	recent [maxNReordering]uint64
+
+	reordered   int
+	extentSum   int
+	maxExtent   int
+	nReordering [maxNReordering]int
+}
+
+func (r *reorderState) observe(seq uint64) {
+	i := r.arrivals
+	r.arrivals++
+	defer func() { r.recent[i%maxNReordering] = seq }()
+
+	if i == 0 || seq >= r.nextExp {
+		r.nextExp = seq + 1
+		if len(r.records) >= maxReorderRecords {
+			r.records = append(r.records[:0], r.records[len(r.records)/2:]...)
+		}
+		r.records = append(r.records, reorderRecord{seq: seq, index: i})
+		return
+	}
+
+	r.reordered++
+
+	// Extent: distance back to the earliest arrival with a larger sequence
+	// number (RFC 4737 section 4.2).
+	// A repeated sequence number has no larger record.
+	if k := sort.Search(len(r.records), func(k int) bool {
+		return r.records[k].seq > seq
+	}); k < len(r.records) {
+		extent := i - r.records[k].index
+		r.extentSum += extent
+		if extent > r.maxExtent {
+			r.maxExtent = extent
+		}
+	}
+
+	// n-reordering: the number of immediately preceding arrivals that all
+	// carry larger sequence numbers (RFC 4737 section 5), counted up to the
+	// largest n reported.
+	n := 0
+	for j := i - 1; j >= 0 && n < maxNReordering && r.recent[j%maxNReordering] > seq; j-- {
+		n++
+	}
+	if n > 0 {
+		r.nReordering[n-1]++
+	}
+}
+
+func (r *reorderState) report() ReorderReport {
+	report := ReorderReport{
+		Reordered:   r.reordered,
+		MaxExtent:   r.maxExtent,
+		NReordering: append([]int(nil), r.nReordering[:]...),
+	}
+	if r.arrivals > 0 {
+		report.Ratio = float64(r.reordered) / float64(r.arrivals)
+	}
+	if r.reordered > 0 {
+		report.MeanExtent = float64(r.extentSum) / float64(r.reordered)
+	}
+	return report
+}
+
+// Summarize returns the distribution of values.
+func Summarize(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return Distribution{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Max:   sorted[len(sorted)-1],
+		Mean:  sum / float64(len(sorted)),
+		P50:   Percentile(sorted, 0.50),
+		P90:   Percentile(sorted, 0.90),
+		P99:   Percentile(sorted, 0.99),
+	}
+}
+
+// Percentile returns the nearest-rank percentile p (0..1) of sorted values.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
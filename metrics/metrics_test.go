@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// arrivalsOf builds received samples that arrive one millisecond apart in the
+// given sequence order.
+func arrivalsOf(seqs ...uint64) []Sample {
+	out := make([]Sample, len(seqs))
+	for i, seq := range seqs {
+		out[i] = Sample{Seq: seq, Sent: float64(seq), Received: float64(i) + 100}
+	}
+	return out
+}
+
+// withDelays builds received samples with consecutive sequence numbers sent
+// 20 ms apart and the given one-way delays.
+func withDelays(delays ...float64) []Sample {
+	out := make([]Sample, len(delays))
+	for i, d := range delays {
+		sent := float64(i) * 20
+		out[i] = Sample{Seq: uint64(i), Sent: sent, Received: sent + d}
+	}
+	return out
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// RFC 3550 section 6.4.1 and appendix A.8: J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16.
+func TestInterarrivalJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		delays []float64
+		want   float64
+	}{
+		{"single packet", []float64{10}, 0},
+		{"constant delay", []float64{10, 10, 10, 10}, 0},
+		{"one step", []float64{0, 16}, 1},
+		{"step and back", []float64{0, 16, 0}, 1 + (16-1)/16.0},
+		{"sign of D is ignored", []float64{16, 0}, 1},
+		{"three steps", []float64{0, 32, 32, 0}, 2 + (0-2)/16.0 + (32-(2+(0-2)/16.0))/16.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InterarrivalJitter(withDelays(tt.delays...))
+			if !almostEqual(got, tt.want) {
+				t.Errorf("InterarrivalJitter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// RFC 5481 section 4.1 (IPDV between consecutive sequence numbers) and
+// section 4.2 (PDV relative to the minimum delay).
+func TestDelayVariation(t *testing.T) {
+	samples := withDelays(10, 12, 11, 15, 10)
+	// Sequence 5 is lost, so the pair (4, 6) has no IPDV value.
+	samples = append(samples,
+		Sample{Seq: 5, Lost: true},
+		Sample{Seq: 6, Sent: 120, Received: 134},
+	)
+
+	ipdv := IPDV(samples)
+	if ipdv.Count != 4 {
+		t.Fatalf("IPDV count = %d, want 4", ipdv.Count)
+	}
+	if ipdv.Min != -5 || ipdv.Max != 4 {
+		t.Errorf("IPDV min/max = %v/%v, want -5/4", ipdv.Min, ipdv.Max)
+	}
+	// 2, -1, 4, -5
+	if !almostEqual(ipdv.Mean, 0) || !almostEqual(ipdv.MeanAbs, 3) {
+		t.Errorf("IPDV mean/meanAbs = %v/%v, want 0/3", ipdv.Mean, ipdv.MeanAbs)
+	}
+
+	pdv := PDV([]float64{10, 12, 11, 15, 10, 14})
+	want := Distribution{Count: 6, Min: 0, Max: 5, Mean: 12.0 / 6, P50: 1, P90: 5, P99: 5}
+	if pdv != want {
+		t.Errorf("PDV = %+v, want %+v", pdv, want)
+	}
+}
+
+// RFC 4737 section 4.2 (reordering extent) and section 5 (n-reordering).
+func TestReordering(t *testing.T) {
+	tests := []struct {
+		name      string
+		order     []uint64
+		reordered int
+		maxExtent int
+		extentSum int
+		n         map[int]int
+	}{
+		{"in order", []uint64{1, 2, 3, 4, 5}, 0, 0, 0, nil},
+		{"gaps are not reordering", []uint64{1, 2, 5, 6}, 0, 0, 0, nil},
+		// 4 arrives after 5 and 6: extent 2, 2-reordered.
+		{"single late packet", []uint64{1, 2, 3, 5, 6, 4, 7}, 1, 2, 2, map[int]int{2: 1}},
+		// 4 and 5 both arrive after 6. 4: extent 1, 1-reordered. 5 is also
+		// reordered (nextExp is 7): the earliest larger arrival is 6, extent 2,
+		// but the immediately preceding 4 is smaller, so it is not n-reordered.
+		{"two late packets", []uint64{1, 2, 3, 6, 4, 5}, 2, 2, 3, map[int]int{1: 1}},
+		// 1 arrives last: its extent counts back to 2, the first arrival.
+		{"first packet last", []uint64{2, 3, 4, 5, 1}, 1, 4, 4, map[int]int{4: 1}},
+		// Swapped pairs: each late packet is 1-reordered with extent 1.
+		{"swapped pairs", []uint64{2, 1, 4, 3, 6, 5}, 3, 1, 3, map[int]int{1: 3}},
+		// n is counted up to maxNReordering.
+		{"deep reordering", []uint64{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 1}, 1, 10, 10, map[int]int{maxNReordering: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Reordering(arrivalsOf(tt.order...))
+			if got.Reordered != tt.reordered || got.MaxExtent != tt.maxExtent {
+				t.Errorf("reordered/maxExtent = %d/%d, want %d/%d",
+					got.Reordered, got.MaxExtent, tt.reordered, tt.maxExtent)
+			}
+			if tt.reordered > 0 {
+				wantMean := float64(tt.extentSum) / float64(tt.reordered)
+				if !almostEqual(got.MeanExtent, wantMean) {
+					t.Errorf("meanExtent = %v, want %v", got.MeanExtent, wantMean)
+				}
+			}
+			wantN := make([]int, maxNReordering)
+			for n, count := range tt.n {
+				wantN[n-1] = count
+			}
+			if !reflect.DeepEqual(got.NReordering, wantN) {
+				t.Errorf("nReordering = %v, want %v", got.NReordering, wantN)
+			}
+		})
+	}
+}
+
+// reorderingByScan is the direct reading of RFC 4737: the extent of a
+// reordered packet is found by scanning every earlier arrival.
+func reorderingByScan(arrivals []Sample) (reordered, maxExtent, extentSum int) {
+	nextExp := arrivals[0].Seq + 1
+	for i := 1; i < len(arrivals); i++ {
+		seq := arrivals[i].Seq
+		if seq >= nextExp {
+			nextExp = seq + 1
+			continue
+		}
+		reordered++
+		for j := 0; j < i; j++ {
+			if arrivals[j].Seq > seq {
+				extentSum += i - j
+				if i-j > maxExtent {
+					maxExtent = i - j
+				}
+				break
+			}
+		}
+	}
+	return reordered, maxExtent, extentSum
+}
+
+func TestReorderingMatchesScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 50; run++ {
+		seqs := make([]uint64, 500)
+		for i := range seqs {
+			seqs[i] = uint64(i)
+		}
+		// Displace packets by up to 20 positions.
+		for i := range seqs {
+			j := i + rng.Intn(20)
+			if j < len(seqs) {
+				seqs[i], seqs[j] = seqs[j], seqs[i]
+			}
+		}
+		arrivals := arrivalsOf(seqs...)
+		reordered, maxExtent, extentSum := reorderingByScan(arrivals)
+		got := Reordering(arrivals)
+		if got.Reordered != reordered || got.MaxExtent != maxExtent ||
+			!almostEqual(got.MeanExtent*float64(got.Reordered), float64(extentSum)) {
+			t.Fatalf("run %d: got %d/%d/%v, scan gives %d/%d/%d", run,
+				got.Reordered, got.MaxExtent, got.MeanExtent, reordered, maxExtent, extentSum)
+		}
+	}
+}
+
+func TestComputeLossAndDuplicates(t *testing.T) {
+	samples := []Sample{
+		{Seq: 0, Sent: 0, Received: 10},
+		{Seq: 1, Lost: true},
+		{Seq: 2, Sent: 40, Received: 52},
+		{Seq: 2, Sent: 40, Received: 53},
+		{Seq: 3, Lost: true},
+		{Seq: 3, Sent: 60, Received: 71}, // a late copy replaces the loss
+	}
+	r := Compute(samples)
+	if r.Sent != 4 || r.Received != 3 || r.Lost != 1 || r.Duplicates != 2 {
+		t.Errorf("sent/received/lost/duplicates = %d/%d/%d/%d, want 4/3/1/2",
+			r.Sent, r.Received, r.Lost, r.Duplicates)
+	}
+	if !almostEqual(r.LossRatio, 0.25) {
+		t.Errorf("lossRatio = %v, want 0.25", r.LossRatio)
+	}
+	if r.Latency.Min != 10 || r.Latency.Max != 12 {
+		t.Errorf("latency min/max = %v/%v, want 10/12", r.Latency.Min, r.Latency.Max)
+	}
+}
+
+func BenchmarkReordering(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	seqs := make([]uint64, 1<<20)
+	for i := range seqs {
+		seqs[i] = uint64(i)
+	}
+	for i := range seqs {
+		if j := i + rng.Intn(1000); j < len(seqs) {
+			seqs[i], seqs[j] = seqs[j], seqs[i]
+		}
+	}
+	arrivals := arrivalsOf(seqs...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Reordering(arrivals)
+	}
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import "sort"
+
+const (
+	// adaptiveGain is the smoothing factor of the adaptive delay estimate
+	// (Ramjee et al., "Adaptive Playout Mechanisms for Packetized Audio").
+	adaptiveGain = 0.998
+	// adaptiveMargin is how many mean deviations the adaptive buffer keeps
+	// above the smoothed delay.
+	adaptiveMargin = 4.0
+)
+
+// DefaultPlayoutDepths are the buffer depths simulated when none are given.
+var DefaultPlayoutDepths = []float64{20, 40, 60, 80, 100, 150, 200}
+
+// PlayoutResult is the outcome of playing a run through one jitter buffer.
+type PlayoutResult struct {
+	Mode          string  `json:"mode"`  // "fixed" or "adaptive"
+	Depth         float64 `json:"depth"` // milliseconds
+	Sent          int     `json:"sent"`
+	NetworkLost   int     `json:"networkLost"`
+	Late          int     `json:"late"`
+	EffectiveLoss float64 `json:"effectiveLoss"` // (network lost + late) / sent
+	AddedDelay    float64 `json:"addedDelay"`    // mean time played packets waited in the buffer, milliseconds
+}
+
+// SimulatePlayout plays samples through a fixed and an adaptive jitter buffer
+// for each depth. A packet that arrives after its playout deadline counts as
+// late and is lost to the application.
+//
+// The fixed buffer anchors playout to the first received packet: packet i is
+// due at Sent[i] + Delay[first] + depth. The adaptive buffer starts at the
+// same deadline and then tracks a smoothed delay estimate plus four mean
+// deviations, capped at depth above the first packet's delay.
+func SimulatePlayout(samples []Sample, depths []float64) []PlayoutResult {
+	if len(depths) == 0 {
+		depths = DefaultPlayoutDepths
+	}
+	bySeq := dedupe(samples)
+
+	results := make([]PlayoutResult, 0, 2*len(depths))
+	for _, depth := range depths {
+		results = append(results, playFixed(bySeq, depth), playAdaptive(bySeq, depth))
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Mode != results[j].Mode {
+			return results[i].Mode == "fixed"
+		}
+		return results[i].Depth < results[j].Depth
+	})
+	return results
+}
+
+func playFixed(bySeq []Sample, depth float64) PlayoutResult {
+	result := PlayoutResult{Mode: "fixed", Depth: depth, Sent: len(bySeq)}
+
+	var base float64
+	var anchored bool
+	var waitSum float64
+	var played int
+	for _, s := range bySeq {
+		if s.Lost {
+			result.NetworkLost++
+			continue
+		}
+		if !anchored {
+			base = s.Delay()
+			anchored = true
+		}
+		deadline := s.Sent + base + depth
+		if s.Received > deadline {
+			result.Late++
+			continue
+		}
+		waitSum += deadline - s.Received
+		played++
+	}
+	return finishPlayout(result, waitSum, played)
+}
+
+func playAdaptive(bySeq []Sample, depth float64) PlayoutResult {
+	result := PlayoutResult{Mode: "adaptive", Depth: depth, Sent: len(bySeq)}
+
+	var base, estimate, deviation float64
+	var anchored bool
+	var waitSum float64
+	var played int
+	for _, s := range bySeq {
+		if s.Lost {
+			result.NetworkLost++
+			continue
+		}
+		delay := s.Delay()
+		if !anchored {
+			// Start at the full depth and shrink while the path is steady.
+			base, estimate = delay, delay
+			deviation = depth / adaptiveMargin
+			anchored = true
+		}
+
+		// The deadline uses only what was known before this packet arrived.
+		target := estimate + adaptiveMargin*deviation
+		if limit := base + depth; target > limit {
+			target = limit
+		}
+		deadline := s.Sent + target
+
+		estimate = adaptiveGain*estimate + (1-adaptiveGain)*delay
+		diff := estimate - delay
+		if diff < 0 {
+			diff = -diff
+		}
+		deviation = adaptiveGain*deviation + (1-adaptiveGain)*diff
+
+		if s.Received > deadline {
+			result.Late++
+			continue
+		}
+		waitSum += deadline - s.Received
+		played++
+	}
+	return finishPlayout(result, waitSum, played)
+}
+
+func finishPlayout(result PlayoutResult, waitSum float64, played int) PlayoutResult {
+	if result.Sent > 0 {
+		result.EffectiveLoss = float64(result.NetworkLost+result.Late) / float64(result.Sent)
+	}
+	if played > 0 {
+		result.AddedDelay = waitSum / float64(played)
+	}
+	return result
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+// playout returns the result of one mode and depth.
+func playout(t *testing.T, samples []Sample, mode string, depth float64) PlayoutResult {
+	t.Helper()
+	for _, r := range SimulatePlayout(samples, []float64{depth}) {
+		if r.Mode == mode {
+			return r
+		}
+	}
+	t.Fatalf("no %s result", mode)
+	return PlayoutResult{}
+}
+
+// repeatDelays repeats a delay pattern n times.
+func repeatDelays(n int, pattern ...float64) []float64 {
+	out := make([]float64, 0, n*len(pattern))
+	for i := 0; i < n; i++ {
+		out = append(out, pattern...)
+	}
+	return out
+}
+
+func TestPlayoutFixed(t *testing.T) {
+	tests := []struct {
+		name        string
+		samples     []Sample
+		depth       float64
+		networkLost int
+		late        int
+		addedDelay  float64
+	}{
+		{"steady path", withDelays(10, 10, 10), 20, 0, 0, 20},
+		{"late beyond depth", withDelays(10, 25, 40), 20, 0, 1, (20 + 5) / 2.0},
+		{"deadline is inclusive", withDelays(10, 30), 20, 0, 0, (20 + 0) / 2.0},
+		{"deeper buffer absorbs jitter", withDelays(10, 25, 40), 40, 0, 0, (40 + 25 + 10) / 3.0},
+		{
+			"anchors to the first received packet",
+			[]Sample{
+				{Seq: 0, Sent: 0, Lost: true},
+				{Seq: 1, Sent: 20, Received: 60},
+				{Seq: 2, Sent: 40, Received: 60},
+				{Seq: 3, Sent: 60, Received: 130},
+			},
+			20, 1, 1, (20 + 40) / 2.0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := playout(t, tt.samples, "fixed", tt.depth)
+			if r.Sent != len(tt.samples) || r.NetworkLost != tt.networkLost || r.Late != tt.late {
+				t.Errorf("sent/networkLost/late = %d/%d/%d, want %d/%d/%d",
+					r.Sent, r.NetworkLost, r.Late, len(tt.samples), tt.networkLost, tt.late)
+			}
+			wantLoss := float64(tt.networkLost+tt.late) / float64(len(tt.samples))
+			if !almostEqual(r.EffectiveLoss, wantLoss) {
+				t.Errorf("effectiveLoss = %v, want %v", r.EffectiveLoss, wantLoss)
+			}
+			if !almostEqual(r.AddedDelay, tt.addedDelay) {
+				t.Errorf("addedDelay = %v, want %v", r.AddedDelay, tt.addedDelay)
+			}
+		})
+	}
+}
+
+func TestPlayoutAdaptive(t *testing.T) {
+	steady := repeatDelays(3000, 10)
+	tests := []struct {
+		name    string
+		delays  []float64
+		depth   float64
+		maxLate int
+		minLate int
+		// maxAdded bounds the mean buffering delay of played packets.
+		maxAdded float64
+	}{
+		// A steady path lets the buffer shrink well below the fixed depth.
+		{"shrinks on a steady path", steady, 100, 0, 0, 20},
+		// After shrinking, the first spikes are late until the buffer grows
+		// to cover them; a buffer stuck at its shrunk size would lose half.
+		{"grows when jitter returns", append(steady, repeatDelays(1500, 10, 40)...), 100, 300, 1, 50},
+		// Growth is capped at depth above the first packet's delay, so
+		// spikes beyond the cap are always late.
+		{"growth is capped at depth", repeatDelays(1000, 10, 40), 20, 1000, 1000, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := playout(t, withDelays(tt.delays...), "adaptive", tt.depth)
+			if r.Late < tt.minLate || r.Late > tt.maxLate {
+				t.Errorf("late = %d, want %d..%d", r.Late, tt.minLate, tt.maxLate)
+			}
+			if r.AddedDelay > tt.maxAdded {
+				t.Errorf("addedDelay = %v, want at most %v", r.AddedDelay, tt.maxAdded)
+			}
+			fixed := playout(t, withDelays(tt.delays...), "fixed", tt.depth)
+			if r.AddedDelay > fixed.AddedDelay {
+				t.Errorf("adaptive addedDelay %v exceeds fixed %v", r.AddedDelay, fixed.AddedDelay)
+			}
+		})
+	}
+}
+
+func TestPlayoutEmptyAndSingle(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    []Sample
+		sent       int
+		lost       int
+		addedDelay float64
+	}{
+		{"empty", nil, 0, 0, 0},
+		{"single received", withDelays(10), 1, 0, 40},
+		{"single lost", []Sample{{Seq: 0, Lost: true}}, 1, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range SimulatePlayout(tt.samples, []float64{40}) {
+				if r.Sent != tt.sent || r.NetworkLost != tt.lost || r.Late != 0 {
+					t.Errorf("%s: sent/networkLost/late = %d/%d/%d, want %d/%d/0",
+						r.Mode, r.Sent, r.NetworkLost, r.Late, tt.sent, tt.lost)
+				}
+				if tt.sent > 0 && !almostEqual(r.EffectiveLoss, float64(tt.lost)/float64(tt.sent)) {
+					t.Errorf("%s: effectiveLoss = %v", r.Mode, r.EffectiveLoss)
+				}
+				if !almostEqual(r.AddedDelay, tt.addedDelay) {
+					t.Errorf("%s: addedDelay = %v, want %v", r.Mode, r.AddedDelay, tt.addedDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestPlayoutDefaultDepths(t *testing.T) {
+	results := SimulatePlayout(nil, nil)
+	if len(results) != 2*len(DefaultPlayoutDepths) {
+		t.Fatalf("got %d results, want %d", len(results), 2*len(DefaultPlayoutDepths))
+	}
+	for i, r := range results {
+		mode, depth := "fixed", DefaultPlayoutDepths[i%len(DefaultPlayoutDepths)]
+		if i >= len(DefaultPlayoutDepths) {
+			mode = "adaptive"
+		}
+		if r.Mode != mode || r.Depth != depth {
+			t.Errorf("result %d = %s/%v, want %s/%v", i, r.Mode, r.Depth, mode, depth)
+		}
+	}
+}
+
+func TestPlayoutOutOfOrder(t *testing.T) {
+	inOrder := []Sample{
+		{Seq: 0, Sent: 0, Received: 10},
+		{Seq: 1, Sent: 20, Received: 50},
+		{Seq: 2, Sent: 40, Received: 55},
+		{Seq: 3, Sent: 60, Received: 75},
+	}
+	// Reported in arrival order, with a duplicate and a loss report
+	// superseded by a late copy.
+	reported := []Sample{
+		inOrder[0],
+		{Seq: 2, Lost: true},
+		inOrder[2],
+		inOrder[1],
+		inOrder[3],
+		inOrder[2],
+	}
+	got := SimulatePlayout(reported, []float64{20})
+	want := SimulatePlayout(inOrder, []float64{20})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("out-of-order input = %+v, want %+v", got, want)
+	}
+
+	// Playout anchors to the lowest sequence number, not the first report:
+	// seq 1 arrives 30 ms after sending, beyond the 10 + 15 ms deadline.
+	fixed := playout(t, reported, "fixed", 15)
+	if fixed.Sent != 4 || fixed.NetworkLost != 0 || fixed.Late != 1 {
+		t.Errorf("sent/networkLost/late = %d/%d/%d, want 4/0/1", fixed.Sent, fixed.NetworkLost, fixed.Late)
+	}
+}
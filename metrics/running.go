@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// histogramFloor is the smallest magnitude, in milliseconds, told apart
+	// from zero by a histogram.
+	histogramFloor = 0.001
+	// histogramGrowth is the ratio between the bounds of a histogram bin, so
+	// percentiles are accurate to about half a percent.
+	histogramGrowth = 1.01
+)
+
+// Running accumulates the metrics of a run batch by batch, so a report costs
+// the same however long the run has been going. It is meant for round-trip
+// probes, where Sent and Received are on the same clock.
+//
+// Samples must be added in sequence order, as clients report them; a sample
+// that does not advance the sequence number is counted as a duplicate.
+// Percentiles come from histograms rather than from every value, so Compute
+// remains the exact report when all samples are at hand.
+type Running struct {
+	started    bool
+	prev       Sample // last sample added
+	sent       int
+	received   int
+	duplicates int
+
+	latency    histogram
+	ipdv       histogram
+	ipdvAbsSum float64
+
+	// held keeps received samples that a later batch may still precede in
+	// arrival order. Jitter and reordering are computed once they are
+	// released in arrival order.
+	held        []Sample
+	arrived     bool
+	lastArrival Sample
+	jitter      float64
+	reorder     reorderState
+}
+
+// Add records a batch of samples.
+func (r *Running) Add(samples []Sample) {
+	watermark := math.Inf(-1)
+	for _, s := range samples {
+		if r.started && s.Seq <= r.prev.Seq {
+			r.duplicates++
+			continue
+		}
+		if r.started && s.Seq == r.prev.Seq+1 && !s.Lost && !r.prev.Lost {
+			v := s.Delay() - r.prev.Delay()
+			r.ipdv.add(v)
+			r.ipdvAbsSum += math.Abs(v)
+		}
+		r.started = true
+		r.prev = s
+		r.sent++
+		if s.Sent > watermark {
+			watermark = s.Sent
+		}
+		if s.Lost {
+			continue
+		}
+		r.received++
+		r.latency.add(s.Delay())
+		r.held = append(r.held, s)
+	}
+
+	// Later batches hold probes sent after every probe in this one, so
+	// nothing they contain can have arrived before the latest send time.
+	r.release(watermark)
+}
+
+// Flush releases every held sample, for the end of a run.
+func (r *Running) Flush() {
+	r.release(math.Inf(1))
+}
+
+func (r *Running) release(watermark float64) {
+	sort.SliceStable(r.held, func(i, j int) bool {
+		if r.held[i].Received != r.held[j].Received {
+			return r.held[i].Received < r.held[j].Received
+		}
+		return r.held[i].Seq < r.held[j].Seq
+	})
+	n := 0
+	for n < len(r.held) && r.held[n].Received <= watermark {
+		s := r.held[n]
+		if r.arrived {
+			d := s.Delay() - r.lastArrival.Delay()
+			r.jitter += (math.Abs(d) - r.jitter) * jitterGain
+		}
+		r.arrived = true
+		r.lastArrival = s
+		r.reorder.observe(s.Seq)
+		n++
+	}
+	r.held = append(r.held[:0], r.held[n:]...)
+}
+
+// Report returns the metrics of every sample added so far. Arrivals still
+// held back are not yet reflected in the jitter and reordering metrics.
+func (r *Running) Report() Report {
+	report := Report{
+		Sent:       r.sent,
+		Received:   r.received,
+		Lost:       r.sent - r.received,
+		Duplicates: r.duplicates,
+		Latency:    r.latency.distribution(),
+		Jitter:     r.jitter,
+		IPDV:       IPDVStats{Distribution: r.ipdv.distribution()},
+		Reordering: r.reorder.report(),
+	}
+	if r.sent > 0 {
+		report.LossRatio = float64(report.Lost) / float64(r.sent)
+	}
+	if r.ipdv.count > 0 {
+		report.IPDV.MeanAbs = r.ipdvAbsSum / float64(r.ipdv.count)
+	}
+
+	// PDV is each delay less the minimum delay, the latency distribution
+	// shifted down by its minimum.
+	if lat := report.Latency; lat.Count > 0 {
+		report.PDV = Distribution{
+			Count: lat.Count,
+			Max:   lat.Max - lat.Min,
+			Mean:  lat.Mean - lat.Min,
+			P50:   lat.P50 - lat.Min,
+			P90:   lat.P90 - lat.Min,
+			P99:   lat.P99 - lat.Min,
+		}
+	}
+	return report
+}
+
+// histogram counts values in logarithmic bins for percentiles of runs too
+// long to keep every value. Count, minimum, maximum and mean are exact.
+type histogram struct {
+	count    int
+	min, max float64
+	sum      float64
+	bins     map[int]int
+}
+
+func (h *histogram) add(v float64) {
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+	if h.bins == nil {
+		h.bins = make(map[int]int)
+	}
+	h.bins[histogramBin(v)]++
+}
+
+// histogramBin maps a value to its bin. Bin 0 holds magnitudes below
+// histogramFloor; bin b > 0 holds [floor·g^(b-1), floor·g^b) and bin -b the
+// matching negative values, so bins sort in the order of their values.
+func histogramBin(v float64) int {
+	a := math.Abs(v)
+	if a < histogramFloor {
+		return 0
+	}
+	b := int(math.Log(a/histogramFloor)/math.Log(histogramGrowth)) + 1
+	if v < 0 {
+		return -b
+	}
+	return b
+}
+
+// histogramValue returns the geometric middle of a bin.
+func histogramValue(bin int) float64 {
+	if bin == 0 {
+		return 0
+	}
+	v := histogramFloor * math.Pow(histogramGrowth, math.Abs(float64(bin))-0.5)
+	if bin < 0 {
+		return -v
+	}
+	return v
+}
+
+func (h *histogram) distribution() Distribution {
+	if h.count == 0 {
+		return Distribution{}
+	}
+	bins := make([]int, 0, len(h.bins))
+	for bin := range h.bins {
+		bins = append(bins, bin)
+	}
+	sort.Ints(bins)
+
+	// percentile uses the same nearest rank as Percentile, clamped to the
+	// exact extremes.
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p * float64(h.count)))
+		seen := 0
+		for _, bin := range bins {
+			seen += h.bins[bin]
+			if seen >= rank {
+				return math.Min(math.Max(histogramValue(bin), h.min), h.max)
+			}
+		}
+		return h.max
+	}
+	return Distribution{
+		Count: h.count,
+		Min:   h.min,
+		Max:   h.max,
+		Mean:  h.sum / float64(h.count),
+		P50:   percentile(0.50),
+		P90:   percentile(0.90),
+		P99:   percentile(0.99),
+	}
+}
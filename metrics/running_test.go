@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// probeRun simulates round-trip probes sent every 10 ms with random delays,
+// enough to reorder neighbouring probes, and 2% loss.
+func probeRun(n int, seed int64) []Sample {
+	rng := rand.New(rand.NewSource(seed))
+	samples := make([]Sample, n)
+	for i := range samples {
+		sent := float64(i) * 10
+		samples[i] = Sample{Seq: uint64(i), Sent: sent}
+		if rng.Float64() < 0.02 {
+			samples[i].Lost = true
+			continue
+		}
+		samples[i].Received = sent + 20 + rng.ExpFloat64()*15
+	}
+	return samples
+}
+
+func TestRunningMatchesCompute(t *testing.T) {
+	samples := probeRun(20000, 1)
+	want := Compute(samples)
+
+	var r Running
+	rng := rand.New(rand.NewSource(2))
+	for rest := samples; len(rest) > 0; {
+		n := 1 + rng.Intn(100)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		r.Add(rest[:n])
+		rest = rest[n:]
+	}
+	r.Flush()
+	got := r.Report()
+
+	if got.Sent != want.Sent || got.Received != want.Received || got.Lost != want.Lost ||
+		got.Duplicates != want.Duplicates || got.LossRatio != want.LossRatio {
+		t.Errorf("counts = %d/%d/%d/%d, want %d/%d/%d/%d", got.Sent, got.Received, got.Lost,
+			got.Duplicates, want.Sent, want.Received, want.Lost, want.Duplicates)
+	}
+	if !almostEqual(got.Jitter, want.Jitter) {
+		t.Errorf("jitter = %v, want %v", got.Jitter, want.Jitter)
+	}
+	if want.Reordering.Reordered == 0 {
+		t.Fatal("the simulated run has no reordering")
+	}
+	if !reflect.DeepEqual(got.Reordering, want.Reordering) {
+		t.Errorf("reordering = %+v, want %+v", got.Reordering, want.Reordering)
+	}
+	if !almostEqual(got.IPDV.MeanAbs, want.IPDV.MeanAbs) || got.IPDV.Count != want.IPDV.Count {
+		t.Errorf("ipdv = %+v, want %+v", got.IPDV, want.IPDV)
+	}
+
+	within := func(name string, got, want Distribution) {
+		t.Helper()
+		if got.Count != want.Count || !almostEqual(got.Min, want.Min) || !almostEqual(got.Max, want.Max) ||
+			!almostEqual(got.Mean, want.Mean) {
+			t.Errorf("%s = %+v, want %+v", name, got, want)
+		}
+		for _, p := range [][2]float64{{got.P50, want.P50}, {got.P90, want.P90}, {got.P99, want.P99}} {
+			if math.Abs(p[0]-p[1]) > 0.01*math.Abs(p[1])+histogramFloor {
+				t.Errorf("%s percentiles = %+v, want %+v", name, got, want)
+				return
+			}
+		}
+	}
+	within("latency", got.Latency, want.Latency)
+	within("ipdv", got.IPDV.Distribution, want.IPDV.Distribution)
+	if got.PDV.Count != want.PDV.Count || !almostEqual(got.PDV.Max, want.PDV.Max) {
+		t.Errorf("pdv = %+v, want %+v", got.PDV, want.PDV)
+	}
+}
+
+func TestRunningDuplicates(t *testing.T) {
+	var r Running
+	r.Add([]Sample{{Seq: 0, Sent: 0, Received: 10}, {Seq: 1, Sent: 10, Received: 20}})
+	r.Add([]Sample{{Seq: 1, Sent: 10, Received: 21}, {Seq: 2, Sent: 20, Received: 30}})
+	got := r.Report()
+	if got.Sent != 3 || got.Received != 3 || got.Duplicates != 1 {
+		t.Errorf("sent/received/duplicates = %d/%d/%d, want 3/3/1", got.Sent, got.Received, got.Duplicates)
+	}
+}
+
+// BenchmarkRunningReport measures the report a live session sends after
+// every batch, a million probes into the run.
+func BenchmarkRunningReport(b *testing.B) {
+	var r Running
+	r.Add(probeRun(1<<20, 1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Report()
+	}
+}
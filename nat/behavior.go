@@ -0,0 +1,59 @@
+package nat
+
+// Behavior describes how a NAT's mapping or filtering depends on the remote
+// endpoint (RFC 4787 terminology).
+type Behavior string
+
+const (
+	EndpointIndependent     Behavior = "endpoint-independent"
+	AddressDependent        Behavior = "address-dependent"
+	AddressAndPortDependent Behavior = "address-and-port-dependent"
+	// EndpointDependent means the mapping changes with the remote endpoint
+	// but the evidence cannot tell whether the port matters.
+	EndpointDependent Behavior = "endpoint-dependent"
+	Unknown           Behavior = "unknown"
+)
+
+// Common names for the combinations, as users know them.
+const (
+	TypeOpen               = "open" // no NAT
+	TypeFullCone           = "full-cone"
+	TypeRestrictedCone     = "restricted-cone"
+	TypePortRestrictedCone = "port-restricted-cone"
+	TypeCone               = "cone" // endpoint-independent mapping, filtering not tested
+	TypeSymmetric          = "symmetric"
+	TypeUnknown            = "unknown"
+)
+
+// Report is the outcome of NAT behaviour discovery.
+type Report struct {
+	Mapping   Behavior `json:"mapping"`
+	Filtering Behavior `json:"filtering"`
+	Type      string   `json:"type"`
+	// NeedsTURN is set for symmetric NATs: a peer that is also behind a NAT
+	// cannot reach a mapping that changes with every destination.
+	NeedsTURN       bool     `json:"needsTurn"`
+	MappedAddresses []string `json:"mappedAddresses,omitempty"`
+	Notes           []string `json:"notes,omitempty"`
+}
+
+// classify fills in Type and NeedsTURN from the mapping and filtering.
+func (r *Report) classify(open bool) {
+	switch {
+	case open:
+		r.Type = TypeOpen
+	case r.Mapping == Unknown:
+		r.Type = TypeUnknown
+	case r.Mapping != EndpointIndependent:
+		r.Type = TypeSymmetric
+		r.NeedsTURN = true
+	case r.Filtering == EndpointIndependent:
+		r.Type = TypeFullCone
+	case r.Filtering == AddressDependent:
+		r.Type = TypeRestrictedCone
+	case r.Filtering == AddressAndPortDependent:
+		r.Type = TypePortRestrictedCone
+	default:
+		r.Type = TypeCone
+	}
+}
@@ -0,0 +1,95 @@
+package nat
+
+import (
+	"net"
+	"strconv"
+)
+
+// Candidate is a server-reflexive ICE candidate a browser gathered while
+// querying the node's STUN addresses. URL is the STUN server that produced
+// it, when the browser reports one.
+type Candidate struct {
+	URL            string `json:"url,omitempty"`
+	Address        string `json:"address"`
+	Port           int    `json:"port"`
+	RelatedAddress string `json:"relatedAddress,omitempty"`
+	RelatedPort    int    `json:"relatedPort,omitempty"`
+}
+
+func (c Candidate) mapped() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.Port))
+}
+
+// Classify infers the mapping behaviour from the candidates a browser
+// gathered from one socket against urls. Browsers cannot send
+// CHANGE-REQUEST, so filtering stays unknown, and they merge identical
+// candidates, so a missing candidate means its mapping equals another's.
+func Classify(urls URLs, candidates []Candidate) Report {
+	report := Report{Mapping: Unknown, Filtering: Unknown}
+	report.Notes = append(report.Notes, "browsers cannot test filtering; run pltcli nat for the full test")
+	if len(candidates) == 0 {
+		report.Notes = append(report.Notes, "no server-reflexive candidates: UDP to the STUN server may be blocked")
+		report.classify(false)
+		return report
+	}
+
+	// Candidates from different local sockets (interfaces) are not
+	// comparable; use the socket with the most evidence. Browsers that hide
+	// the base address get grouped by public IP instead.
+	groups := make(map[string][]Candidate)
+	var best string
+	for _, c := range candidates {
+		key := c.Address
+		if c.RelatedPort != 0 {
+			key = net.JoinHostPort(c.RelatedAddress, strconv.Itoa(c.RelatedPort))
+		}
+		groups[key] = append(groups[key], c)
+		if len(groups[key]) > len(groups[best]) {
+			best = key
+		}
+	}
+
+	byURL := make(map[string]string)
+	distinct := make(map[string]bool)
+	open := false
+	for _, c := range groups[best] {
+		m := c.mapped()
+		if !distinct[m] {
+			distinct[m] = true
+			report.MappedAddresses = append(report.MappedAddresses, m)
+		}
+		if c.URL != "" {
+			byURL[c.URL] = m
+		}
+		if c.Address == c.RelatedAddress && c.Port == c.RelatedPort {
+			open = true
+		}
+	}
+
+	primary, okPrimary := byURL[urls.Primary]
+	altPort, okAltPort := byURL[urls.AlternatePort]
+	_, okAltAddr := byURL[urls.AlternateAddress]
+	switch {
+	case len(distinct) == 1:
+		report.Mapping = EndpointIndependent
+		if urls.AlternateAddress == "" {
+			report.Notes = append(report.Notes, "server has no alternate address; address-dependent mapping not ruled out")
+		}
+	case urls.AlternateAddress == "" || len(distinct) > 2:
+		// The primary and alternate-port servers differ only in port.
+		report.Mapping = AddressAndPortDependent
+	case okPrimary && okAltPort:
+		if primary != altPort {
+			report.Mapping = AddressAndPortDependent
+		} else {
+			report.Mapping = AddressDependent
+		}
+	case okPrimary && okAltAddr:
+		// The merged alternate-port candidate matched the primary one.
+		report.Mapping = AddressDependent
+	default:
+		report.Mapping = EndpointDependent
+	}
+	report.classify(open)
+	return report
+}
@@ -0,0 +1,226 @@
+package nat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+const (
+	requestAttempts = 3
+	requestTimeout  = 500 * time.Millisecond
+)
+
+var errNoResponse = errors.New("no response")
+
+// Discover runs the RFC 5780 mapping and filtering tests against the STUN
+// server at addr from a single local socket. Against a server without an
+// alternate address only the port-change tests are possible and the report
+// says which conclusions are untested.
+func Discover(ctx context.Context, addr string) (Report, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return Report{}, err
+	}
+	local, err := localAddrFor(server)
+	if err != nil {
+		return Report{}, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: local})
+	if err != nil {
+		return Report{}, err
+	}
+	defer conn.Close()
+	c := &client{ctx: ctx, conn: conn}
+
+	report := Report{Mapping: Unknown, Filtering: Unknown}
+
+	// Mapping test I: the basic binding, which also yields OTHER-ADDRESS.
+	first, err := c.request(server, 0)
+	if err != nil {
+		return report, fmt.Errorf("STUN server did not answer: %w", err)
+	}
+	if first.other == nil {
+		return report, errors.New("STUN server does not support RFC 5780 (no OTHER-ADDRESS)")
+	}
+	report.MappedAddresses = append(report.MappedAddresses, first.mapped.String())
+	open := first.mapped.IP.Equal(local) && first.mapped.Port == conn.LocalAddr().(*net.UDPAddr).Port
+	altIP := !first.other.IP.Equal(server.IP)
+
+	if open {
+		report.Mapping = EndpointIndependent
+	} else {
+		report.Mapping, err = c.mappingTests(server, first, altIP, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+	report.Filtering, err = c.filteringTests(server, altIP, &report)
+	if err != nil {
+		return report, err
+	}
+	report.classify(open)
+	return report, nil
+}
+
+// mappingTests runs mapping tests II and III.
+func (c *client) mappingTests(server *net.UDPAddr, first *response, altIP bool, report *Report) (Behavior, error) {
+	if !altIP {
+		// Only the port can change: a new mapping proves port dependence,
+		// the same mapping cannot exclude address dependence.
+		second, err := c.request(&net.UDPAddr{IP: server.IP, Port: first.other.Port}, 0)
+		if err != nil {
+			return Unknown, err
+		}
+		report.MappedAddresses = append(report.MappedAddresses, second.mapped.String())
+		if !sameAddr(second.mapped, first.mapped) {
+			return AddressAndPortDependent, nil
+		}
+		report.Notes = append(report.Notes, "server has no alternate address; address-dependent mapping not ruled out")
+		return EndpointIndependent, nil
+	}
+
+	// Test II: alternate address, primary port.
+	second, err := c.request(&net.UDPAddr{IP: first.other.IP, Port: server.Port}, 0)
+	if err != nil {
+		return Unknown, err
+	}
+	report.MappedAddresses = append(report.MappedAddresses, second.mapped.String())
+	if sameAddr(second.mapped, first.mapped) {
+		return EndpointIndependent, nil
+	}
+	// Test III: alternate address and port.
+	third, err := c.request(first.other, 0)
+	if err != nil {
+		return Unknown, err
+	}
+	report.MappedAddresses = append(report.MappedAddresses, third.mapped.String())
+	if sameAddr(third.mapped, second.mapped) {
+		return AddressDependent, nil
+	}
+	return AddressAndPortDependent, nil
+}
+
+// filteringTests runs filtering tests II and III: the server answers from
+// another address or port, which the NAT lets through only if its filter
+// is loose enough.
+func (c *client) filteringTests(server *net.UDPAddr, altIP bool, report *Report) (Behavior, error) {
+	if altIP {
+		_, err := c.request(server, changeIP|changePort)
+		if err == nil {
+			return EndpointIndependent, nil
+		}
+		if !errors.Is(err, errNoResponse) {
+			return Unknown, err
+		}
+	}
+	_, err := c.request(server, changePort)
+	switch {
+	case err == nil && altIP:
+		return AddressDependent, nil
+	case err == nil:
+		report.Notes = append(report.Notes, "server has no alternate address; endpoint-independent filtering not ruled out")
+		return AddressDependent, nil
+	case errors.Is(err, errNoResponse):
+		return AddressAndPortDependent, nil
+	default:
+		return Unknown, err
+	}
+}
+
+type client struct {
+	ctx  context.Context
+	conn *net.UDPConn
+}
+
+type response struct {
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+}
+
+// request sends a Binding request with the given CHANGE-REQUEST flags and
+// waits for the matching response from any source, retransmitting a few
+// times. errNoResponse means every attempt timed out.
+func (c *client) request(to *net.UDPAddr, flags uint32) (*response, error) {
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	if flags != 0 {
+		setters = append(setters, stun.RawAttribute{
+			Type:  stun.AttrChangeRequest,
+			Value: []byte{0, 0, 0, byte(flags)},
+		})
+	}
+	setters = append(setters, stun.Fingerprint)
+	req, err := stun.Build(setters...)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for attempt := 0; attempt < requestAttempts; attempt++ {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := c.conn.WriteToUDP(req.Raw, to); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(requestTimeout)
+		c.conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := c.conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			resp := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if resp.Decode() != nil || resp.TransactionID != req.TransactionID {
+				continue
+			}
+			if resp.Type != stun.BindingSuccess {
+				var code stun.ErrorCodeAttribute
+				code.GetFrom(resp)
+				return nil, fmt.Errorf("STUN error %d %s", code.Code, code.Reason)
+			}
+			return parseResponse(resp)
+		}
+	}
+	return nil, errNoResponse
+}
+
+func parseResponse(m *stun.Message) (*response, error) {
+	var xor stun.XORMappedAddress
+	if err := xor.GetFrom(m); err != nil {
+		var mapped stun.MappedAddress
+		if err := mapped.GetFrom(m); err != nil {
+			return nil, errors.New("STUN response has no mapped address")
+		}
+		xor.IP, xor.Port = mapped.IP, mapped.Port
+	}
+	r := &response{mapped: &net.UDPAddr{IP: xor.IP, Port: xor.Port}}
+	var other stun.OtherAddress
+	if other.GetFrom(m) == nil {
+		r.other = &net.UDPAddr{IP: other.IP, Port: other.Port}
+	}
+	return r, nil
+}
+
+// localAddrFor returns the local address the system routes to server from,
+// so that a mapped address equal to it can be recognised as no NAT.
+func localAddrFor(server *net.UDPAddr) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
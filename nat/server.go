@@ -0,0 +1,312 @@
+// Package nat discovers how a client's NAT maps and filters UDP traffic
+// (RFC 5780). It provides the STUN server the tests run against, a client
+// that runs the full behaviour discovery, and a classifier for the partial
+// evidence a browser can gather.
+package nat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/stun"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultPort is the standard STUN port.
+const DefaultPort = 3478
+
+// CHANGE-REQUEST flags (RFC 5780 section 7.2).
+const (
+	changeIP   = 0x04
+	changePort = 0x02
+)
+
+const maxMessageSize = 1500
+
+// readErrorBackoff is the pause after a failed read, so a persistent error
+// does not spin the server.
+const readErrorBackoff = 10 * time.Millisecond
+
+// ServerOptions configures the STUN server.
+type ServerOptions struct {
+	// Port is the primary port; AltPort defaults to Port+1.
+	Port    int
+	AltPort int
+	// IP is the local primary address, required together with AltIP. Without
+	// AltIP the server listens on all addresses and can only change ports.
+	IP    string
+	AltIP string
+	// PublicIP replaces the primary address in RESPONSE-ORIGIN and
+	// OTHER-ADDRESS when the server sits behind a 1:1 NAT.
+	PublicIP string
+}
+
+// Server is an RFC 5389 STUN server with the RFC 5780 behaviour discovery
+// extensions: it listens on a primary and an alternate port, optionally on a
+// second address, and answers CHANGE-REQUEST from the requested socket.
+type Server struct {
+	opts ServerOptions
+	// conns[ip][port]: index 0 is primary, 1 alternate; conns[1] is empty
+	// without an alternate address.
+	conns [2][2]*net.UDPConn
+}
+
+// Listen opens the server's sockets.
+func Listen(opts ServerOptions) (*Server, error) {
+	if opts.Port <= 0 {
+		return nil, errors.New("STUN port must be set")
+	}
+	if opts.AltPort == 0 {
+		opts.AltPort = opts.Port + 1
+	}
+	if opts.AltPort == opts.Port {
+		return nil, errors.New("STUN alternate port must differ from the primary port")
+	}
+	if opts.AltIP != "" && opts.IP == "" {
+		return nil, errors.New("STUN primary IP is required with an alternate IP")
+	}
+
+	s := &Server{opts: opts}
+	ips := []string{opts.IP}
+	if opts.AltIP != "" {
+		ips = append(ips, opts.AltIP)
+	}
+	for i, ip := range ips {
+		for j, port := range []int{opts.Port, opts.AltPort} {
+			conn, err := listenUDP(net.JoinHostPort(ip, strconv.Itoa(port)))
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			s.conns[i][j] = conn
+		}
+	}
+	return s, nil
+}
+
+func listenUDP(addr string) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	// A wildcard socket needs the destination address of each request to
+	// fill in RESPONSE-ORIGIN and OTHER-ADDRESS.
+	ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagDst, true)
+	ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagDst, true)
+	return conn, nil
+}
+
+// Addr returns the primary socket's local address.
+func (s *Server) Addr() net.Addr {
+	return s.conns[0][0].LocalAddr()
+}
+
+// HasAltIP reports whether the server can change its address as well as its port.
+func (s *Server) HasAltIP() bool {
+	return s.conns[1][0] != nil
+}
+
+// Serve answers requests on every socket until the server is closed.
+func (s *Server) Serve() error {
+	errs := make(chan error, 4)
+	n := 0
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			if s.conns[i][j] == nil {
+				continue
+			}
+			n++
+			go func(i, j int) { errs <- s.serve(i, j) }(i, j)
+		}
+	}
+	var first error
+	for ; n > 0; n-- {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			s.Close()
+		}
+	}
+	return first
+}
+
+// Close closes every socket.
+func (s *Server) Close() error {
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			if s.conns[i][j] != nil {
+				s.conns[i][j].Close()
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve(ipIdx, portIdx int) error {
+	conn := s.conns[ipIdx][portIdx]
+	buf := make([]byte, maxMessageSize)
+	oob := make([]byte, len(ipv4.NewControlMessage(ipv4.FlagDst))+len(ipv6.NewControlMessage(ipv6.FlagDst)))
+	for {
+		n, oobn, _, peer, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// Errors such as an ICMP port unreachable from an earlier reply
+			// concern one peer; keep serving everyone else.
+			log.Printf("STUN server read failed on %s: %v", conn.LocalAddr(), err)
+			time.Sleep(readErrorBackoff)
+			continue
+		}
+		if !stun.IsMessage(buf[:n]) {
+			continue
+		}
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := req.Decode(); err != nil || req.Type != stun.BindingRequest {
+			continue
+		}
+		local := receivedOn(conn, oob[:oobn])
+		if err := s.answer(req, peer, local, ipIdx, portIdx); err != nil {
+			log.Printf("STUN server failed to answer %s: %v", peer, err)
+		}
+	}
+}
+
+// answer replies to a Binding request that arrived at local on socket
+// conns[ipIdx][portIdx], from the socket CHANGE-REQUEST asks for.
+func (s *Server) answer(req *stun.Message, peer *net.UDPAddr, local net.IP, ipIdx, portIdx int) error {
+	var flags uint32
+	if raw, err := req.Get(stun.AttrChangeRequest); err == nil {
+		if len(raw) != 4 {
+			return s.reject(req, peer, s.conns[ipIdx][portIdx], stun.CodeBadRequest)
+		}
+		flags = uint32(raw[0])<<24 | uint32(raw[1])<<16 | uint32(raw[2])<<8 | uint32(raw[3])
+	}
+	outIP, outPort := ipIdx, portIdx
+	if flags&changeIP != 0 {
+		if !s.HasAltIP() {
+			return s.reject(req, peer, s.conns[ipIdx][portIdx], stun.CodeUnknownAttribute)
+		}
+		outIP ^= 1
+	}
+	if flags&changePort != 0 {
+		outPort ^= 1
+	}
+	otherIP := ipIdx
+	if s.HasAltIP() {
+		otherIP ^= 1
+	}
+
+	origin := s.socketAddr(outIP, outPort, local)
+	other := s.socketAddr(otherIP, portIdx^1, local)
+	resp, err := stun.Build(
+		stun.NewTransactionIDSetter(req.TransactionID),
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: peer.IP, Port: peer.Port},
+		&stun.MappedAddress{IP: peer.IP, Port: peer.Port},
+		&stun.ResponseOrigin{IP: origin.IP, Port: origin.Port},
+		&stun.OtherAddress{IP: other.IP, Port: other.Port},
+		stun.NewSoftware("pltester"),
+		stun.Fingerprint,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.conns[outIP][outPort].WriteToUDP(resp.Raw, peer)
+	return err
+}
+
+func (s *Server) reject(req *stun.Message, peer *net.UDPAddr, conn *net.UDPConn, code stun.ErrorCode) error {
+	resp, err := stun.Build(
+		stun.NewTransactionIDSetter(req.TransactionID),
+		stun.BindingError,
+		code,
+		stun.NewSoftware("pltester"),
+		stun.Fingerprint,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteToUDP(resp.Raw, peer)
+	return err
+}
+
+// socketAddr is the address clients reach conns[ipIdx][portIdx] at. local is
+// the address the current request arrived at, used for wildcard sockets.
+func (s *Server) socketAddr(ipIdx, portIdx int, local net.IP) *net.UDPAddr {
+	port := s.opts.Port
+	if portIdx == 1 {
+		port = s.opts.AltPort
+	}
+	var ip net.IP
+	switch {
+	case ipIdx == 1:
+		ip = net.ParseIP(s.opts.AltIP)
+	case s.opts.PublicIP != "":
+		ip = net.ParseIP(s.opts.PublicIP)
+	case s.opts.IP != "":
+		ip = net.ParseIP(s.opts.IP)
+	default:
+		ip = local
+	}
+	return &net.UDPAddr{IP: ip, Port: port}
+}
+
+// receivedOn returns the destination address of a request, falling back to
+// the socket's own address.
+func receivedOn(conn *net.UDPConn, oob []byte) net.IP {
+	var cm4 ipv4.ControlMessage
+	if cm4.Parse(oob) == nil && cm4.Dst != nil {
+		return cm4.Dst
+	}
+	var cm6 ipv6.ControlMessage
+	if cm6.Parse(oob) == nil && cm6.Dst != nil {
+		return cm6.Dst
+	}
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// URLs are the STUN server addresses a browser queries to compare its
+// mappings. AlternateAddress is empty without a second server address.
+type URLs struct {
+	Primary          string `json:"primary"`
+	AlternatePort    string `json:"alternatePort"`
+	AlternateAddress string `json:"alternateAddress,omitempty"`
+}
+
+// URLs returns the server's addresses as stun: URLs. host is how the client
+// reached this node and is used unless a public IP is configured.
+func (s *Server) URLs(host string) URLs {
+	if s.opts.PublicIP != "" {
+		host = s.opts.PublicIP
+	}
+	u := URLs{
+		Primary:       stunURL(host, s.opts.Port),
+		AlternatePort: stunURL(host, s.opts.AltPort),
+	}
+	if s.HasAltIP() {
+		u.AlternateAddress = stunURL(s.opts.AltIP, s.opts.Port)
+	}
+	return u
+}
+
+// ICEServers returns the address ICE should use for server-reflexive
+// candidates, or nil for a nil *URLs.
+func (u *URLs) ICEServers() []string {
+	if u == nil {
+		return nil
+	}
+	return []string{u.Primary}
+}
+
+func stunURL(host string, port int) string {
+	return fmt.Sprintf("stun:%s", net.JoinHostPort(host, strconv.Itoa(port)))
+}
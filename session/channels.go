@@ -0,0 +1,128 @@
+package session
+
+import (
+	"fmt"
+
+	"pltester/metrics"
+)
+
+// maxChannels bounds the channels of a comparison test kept per session.
+const maxChannels = 8
+
+// Inflation is how much later a channel delivered than the baseline channel,
+// in milliseconds, at several points of the latency distribution.
+type Inflation struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+}
+
+// ChannelReport is the outcome of one DataChannel of a parallel reliability
+// comparison.
+type ChannelReport struct {
+	Channel          string         `json:"channel"`
+	DeliveredRatio   float64        `json:"deliveredRatio"`
+	LatencyInflation Inflation      `json:"latencyInflation"`
+	Report           metrics.Report `json:"report"`
+}
+
+// channelRun is one channel of a comparison test. Like the session's main
+// probes it keeps running metrics for the reports sent during the run and
+// computes the exact report once the channel is complete.
+type channelRun struct {
+	samples  []metrics.Sample
+	trimmed  bool // samples no longer holds the whole run
+	running  metrics.Running
+	report   metrics.Report
+	complete bool
+}
+
+func (c *channelRun) add(samples []metrics.Sample, final bool) {
+	c.running.Add(samples)
+	c.samples = append(c.samples, samples...)
+	if over := len(c.samples) - maxSamples; over > 0 {
+		c.samples = c.samples[over:]
+		c.trimmed = true
+	}
+	if final {
+		c.complete = true
+		c.finish()
+	} else {
+		c.report = c.running.Report()
+	}
+}
+
+func (c *channelRun) finish() {
+	c.running.Flush()
+	if c.trimmed {
+		c.report = c.running.Report()
+	} else {
+		c.report = metrics.Compute(c.samples)
+	}
+}
+
+// AddChannelSamples records probes sent over one named channel of a
+// comparison test, final marking the channel's last batch. They are kept
+// apart from the session's main probes. Samples of a new channel are
+// refused once maxChannels are recorded.
+func (s *Session) AddChannelSamples(channel string, samples []metrics.Sample, final bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.channels == nil {
+		s.channels = make(map[string]*channelRun)
+	}
+	run, ok := s.channels[channel]
+	if !ok {
+		if len(s.channelOrder) >= maxChannels {
+			return fmt.Errorf("too many channels, at most %d", maxChannels)
+		}
+		run = &channelRun{}
+		s.channels[channel] = run
+		s.channelOrder = append(s.channelOrder, channel)
+	}
+	if run.complete {
+		return fmt.Errorf("channel %q already complete", channel)
+	}
+	run.add(samples, final)
+	return nil
+}
+
+// ChannelReports returns every channel's latest metrics, with latency
+// inflation measured against the baseline channel. Without baseline samples
+// the inflation is left zero. Reports are exact once the session finishes.
+func (s *Session) ChannelReports(baseline string) []ChannelReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]ChannelReport, 0, len(s.channelOrder))
+	var base *metrics.Report
+	for _, channel := range s.channelOrder {
+		report := s.channels[channel].report
+		cr := ChannelReport{Channel: channel, Report: report}
+		if report.Sent > 0 {
+			cr.DeliveredRatio = float64(report.Received) / float64(report.Sent)
+		}
+		reports = append(reports, cr)
+		if channel == baseline {
+			base = &reports[len(reports)-1].Report
+		}
+	}
+	if base == nil || base.Latency.Count == 0 {
+		return reports
+	}
+	for i := range reports {
+		latency := reports[i].Report.Latency
+		if latency.Count == 0 {
+			continue
+		}
+		reports[i].LatencyInflation = Inflation{
+			Mean: latency.Mean - base.Latency.Mean,
+			P50:  latency.P50 - base.Latency.P50,
+			P90:  latency.P90 - base.Latency.P90,
+			P99:  latency.P99 - base.Latency.P99,
+		}
+	}
+	return reports
+}
@@ -0,0 +1,53 @@
+package session
+
+import "time"
+
+// maxInterruptions bounds the interruptions kept per session.
+const maxInterruptions = 1000
+
+// Kinds of interruption a session can survive.
+const (
+	// InterruptionSignaling is the signaling WebSocket dropping and a new
+	// one resuming the session.
+	InterruptionSignaling = "signaling"
+	// InterruptionICE is the ICE connection going disconnected or failed
+	// and recovering, on its own or through an ICE restart.
+	InterruptionICE = "ice"
+)
+
+// Interruption is a break in a session's connectivity that did not end the
+// session, such as a phone moving from Wi-Fi to cellular mid-test.
+type Interruption struct {
+	Kind     string    `json:"kind"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // milliseconds
+	// Detail describes how the session recovered, e.g. the new ICE path.
+	Detail string `json:"detail,omitempty"`
+}
+
+// AddInterruption records an interruption lasting from start to end,
+// dropping the oldest beyond maxInterruptions.
+func (s *Session) AddInterruption(kind string, start, end time.Time, detail string) Interruption {
+	in := Interruption{
+		Kind:     kind,
+		Start:    start,
+		End:      end,
+		Duration: float64(end.Sub(start)) / float64(time.Millisecond),
+		Detail:   detail,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interruptions = append(s.interruptions, in)
+	if over := len(s.interruptions) - maxInterruptions; over > 0 {
+		s.interruptions = s.interruptions[over:]
+	}
+	return in
+}
+
+// Interruptions returns the interruptions recorded so far.
+func (s *Session) Interruptions() []Interruption {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Interruption{}, s.interruptions...)
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"time"
+
+	"pltester/metrics"
+)
+
+// defaultOutageThreshold is the shortest run of lost probes recorded as an
+// outage when none is configured.
+const defaultOutageThreshold = 200 * time.Millisecond
+
+// Outage is a run of consecutive lost probes that lasted at least the
+// configured threshold.
+type Outage struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Duration    float64   `json:"duration"` // milliseconds
+	PacketsLost int       `json:"packetsLost"`
+	FirstSeq    uint64    `json:"firstSeq"`
+	LastSeq     uint64    `json:"lastSeq"`
+}
+
+// outageDetector tracks runs of lost probes across sample batches. Samples
+// must be fed in sequence order.
+type outageDetector struct {
+	threshold float64 // milliseconds
+
+	started bool
+	lastSeq uint64
+
+	inRun     bool
+	runStart  float64
+	runFirst  uint64
+	runLast   uint64
+	runLength int
+	runEnd    float64
+}
+
+// observe feeds one sample and returns a finished run, if the sample ended
+// one. The run ends at the send time of the first probe that got through.
+func (d *outageDetector) observe(s metrics.Sample) (run outageRun, ok bool) {
+	if d.started && s.Seq <= d.lastSeq {
+		return outageRun{}, false
+	}
+	d.started = true
+	d.lastSeq = s.Seq
+
+	if s.Lost {
+		if !d.inRun {
+			d.inRun = true
+			d.runStart = s.Sent
+			d.runFirst = s.Seq
+			d.runLength = 0
+		}
+		d.runLast = s.Seq
+		d.runLength++
+		d.runEnd = s.Sent
+		return outageRun{}, false
+	}
+
+	if !d.inRun {
+		return outageRun{}, false
+	}
+	d.runEnd = s.Sent
+	return d.finish()
+}
+
+// flush ends any run still in progress at the send time of its last probe.
+func (d *outageDetector) flush() (outageRun, bool) {
+	if !d.inRun {
+		return outageRun{}, false
+	}
+	return d.finish()
+}
+
+func (d *outageDetector) finish() (outageRun, bool) {
+	d.inRun = false
+	run := outageRun{
+		start:  d.runStart,
+		end:    d.runEnd,
+		first:  d.runFirst,
+		last:   d.runLast,
+		length: d.runLength,
+	}
+	return run, run.end-run.start >= d.threshold
+}
+
+// outageRun is a run of lost probes in the client's clock.
+type outageRun struct {
+	start, end  float64
+	first, last uint64
+	length      int
+}
@@ -0,0 +1,390 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"pltester/metrics"
+)
+
+const (
+	// maxSamples bounds the raw probe history kept per session.
+	maxSamples = 1 << 20
+	// maxBuckets bounds the time series kept per session (24h of 1s buckets).
+	maxBuckets = 24 * 60 * 60
+	// maxOutages bounds the outage events kept per session.
+	maxOutages = 10000
+)
+
+// Bucket aggregates every probe sent within one fixed time window.
+type Bucket struct {
+	Start     time.Time            `json:"start"`
+	Offset    float64              `json:"offset"` // seconds since the first probe
+	Sent      int                  `json:"sent"`
+	Lost      int                  `json:"lost"`
+	LossRatio float64              `json:"lossRatio"`
+	RTT       metrics.Distribution `json:"rtt"`
+	Jitter    float64              `json:"jitter"`
+}
+
+// Summary is the JSON view of a session.
+type Summary struct {
+	ID        string         `json:"id"`
+	Transport string         `json:"transport"`
+	StartedAt time.Time      `json:"startedAt"`
+	EndedAt   *time.Time     `json:"endedAt,omitempty"`
+	Report    metrics.Report `json:"report"`
+	Outages   []Outage       `json:"outages"`
+	// Interruptions lists signaling and ICE breaks the session survived.
+	Interruptions []Interruption `json:"interruptions,omitempty"`
+	// States lists the PeerConnection state transitions, if any.
+	States []StateChange `json:"states,omitempty"`
+	// Results holds the outcome of additional test modes, keyed by mode.
+	Results map[string]interface{} `json:"results,omitempty"`
+}
+
+// Update is the result of recording one batch of samples.
+type Update struct {
+	Report  metrics.Report
+	Buckets []Bucket // buckets closed by this batch
+	Outages []Outage // outages that ended in this batch
+}
+
+// Transports a session's probes can travel over.
+const (
+	TransportDataChannel = "datachannel"
+	TransportWebSocket   = "websocket"
+	TransportQUIC        = "quic" // QUIC DATAGRAM, natively or over WebTransport
+)
+
+// Session is the server-side record of one test run.
+type Session struct {
+	ID        string
+	Transport string
+	StartedAt time.Time
+
+	mu          sync.Mutex
+	endedAt     time.Time
+	complete    bool    // the client has reported its final samples
+	bucketWidth float64 // milliseconds
+	samples     []metrics.Sample
+	trimmed     bool // samples no longer holds the whole run
+	running     metrics.Running
+	report      metrics.Report
+
+	// origin anchors the client's clock: originSent is the send time of the
+	// first probe and originTime the matching server wall time.
+	originSet  bool
+	originSent float64
+	originTime time.Time
+
+	open    map[int64][]metrics.Sample
+	buckets []Bucket
+
+	detector outageDetector
+	outages  []Outage
+
+	interruptions []Interruption
+	states        []StateChange
+
+	results map[string]interface{}
+
+	// channels holds the probes of a parallel DataChannel comparison, keyed
+	// by channel name in the order the channels first reported.
+	channels     map[string]*channelRun
+	channelOrder []string
+}
+
+func newSession(transport string, opts Options) *Session {
+	return &Session{
+		ID:          newID(),
+		Transport:   transport,
+		StartedAt:   time.Now(),
+		bucketWidth: float64(opts.BucketWidth) / float64(time.Millisecond),
+		open:        make(map[int64][]metrics.Sample),
+		detector:    outageDetector{threshold: float64(opts.OutageThreshold) / float64(time.Millisecond)},
+	}
+}
+
+// AddSamples records a batch of probes. When final is true every open bucket
+// is closed and any outage in progress is ended.
+func (s *Session) AddSamples(samples []metrics.Sample, final bool) Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.originSet && len(samples) > 0 {
+		s.setOrigin(samples)
+	}
+
+	s.running.Add(samples)
+	s.samples = append(s.samples, samples...)
+	if over := len(s.samples) - maxSamples; over > 0 {
+		s.samples = s.samples[over:]
+		s.trimmed = true
+	}
+
+	// Probes are reported in send order, so a sample in a later bucket means
+	// every earlier bucket is complete.
+	var newest int64 = -1
+	for _, sample := range samples {
+		idx := s.bucketIndex(sample.Sent)
+		if idx < 0 {
+			continue
+		}
+		s.open[idx] = append(s.open[idx], sample)
+		if idx > newest {
+			newest = idx
+		}
+	}
+
+	limit := newest
+	if final {
+		limit = math.MaxInt64
+		s.complete = true
+	}
+
+	update := Update{
+		Buckets: s.closeBuckets(limit),
+		Outages: s.detectOutages(samples, final),
+	}
+	if final {
+		s.finish()
+	} else {
+		s.report = s.running.Report()
+	}
+	update.Report = s.report
+	return update
+}
+
+// finish computes the final report. Batches in between only update the
+// running metrics, whose percentiles are approximate; the final report is
+// exact as long as the whole run is still held.
+func (s *Session) finish() {
+	s.running.Flush()
+	if s.trimmed {
+		s.report = s.running.Report()
+	} else {
+		s.report = metrics.Compute(s.samples)
+	}
+}
+
+// detectOutages feeds a batch to the outage detector in sequence order.
+func (s *Session) detectOutages(samples []metrics.Sample, final bool) []Outage {
+	sorted := make([]metrics.Sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Seq < sorted[j].Seq })
+
+	var found []Outage
+	for _, sample := range sorted {
+		if run, ok := s.detector.observe(sample); ok {
+			found = append(found, s.outageFromRun(run))
+		}
+	}
+	if final {
+		if run, ok := s.detector.flush(); ok {
+			found = append(found, s.outageFromRun(run))
+		}
+	}
+	s.addOutages(found...)
+	return found
+}
+
+// addOutages records outage events, dropping the oldest beyond maxOutages.
+func (s *Session) addOutages(outages ...Outage) {
+	s.outages = append(s.outages, outages...)
+	if over := len(s.outages) - maxOutages; over > 0 {
+		s.outages = s.outages[over:]
+	}
+}
+
+func (s *Session) outageFromRun(run outageRun) Outage {
+	return Outage{
+		Start:       s.clockTime(run.start),
+		End:         s.clockTime(run.end),
+		Duration:    run.end - run.start,
+		PacketsLost: run.length,
+		FirstSeq:    run.first,
+		LastSeq:     run.last,
+	}
+}
+
+// clockTime maps a send time on the client's clock to server wall time.
+func (s *Session) clockTime(sent float64) time.Time {
+	return s.originTime.Add(time.Duration((sent - s.originSent) * float64(time.Millisecond)))
+}
+
+func (s *Session) setOrigin(samples []metrics.Sample) {
+	first, last := samples[0].Sent, samples[0].Sent
+	for _, sample := range samples[1:] {
+		if sample.Sent < first {
+			first = sample.Sent
+		}
+		if sample.Sent > last {
+			last = sample.Sent
+		}
+	}
+	s.originSet = true
+	s.originSent = first
+	s.originTime = time.Now().Add(-time.Duration((last - first) * float64(time.Millisecond)))
+}
+
+func (s *Session) bucketIndex(sent float64) int64 {
+	offset := sent - s.originSent
+	if offset < 0 {
+		return -1
+	}
+	return int64(offset / s.bucketWidth)
+}
+
+// closeBuckets finalises every open bucket with an index below limit, in
+// index order.
+func (s *Session) closeBuckets(limit int64) []Bucket {
+	var indexes []int64
+	for idx := range s.open {
+		if idx < limit {
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	closed := make([]Bucket, 0, len(indexes))
+	for _, idx := range indexes {
+		report := metrics.Compute(s.open[idx])
+		delete(s.open, idx)
+
+		offset := float64(idx) * s.bucketWidth
+		closed = append(closed, Bucket{
+			Start:     s.clockTime(s.originSent + offset),
+			Offset:    offset / 1000,
+			Sent:      report.Sent,
+			Lost:      report.Lost,
+			LossRatio: report.LossRatio,
+			RTT:       report.Latency,
+			Jitter:    report.Jitter,
+		})
+	}
+
+	s.buckets = append(s.buckets, closed...)
+	if over := len(s.buckets) - maxBuckets; over > 0 {
+		s.buckets = s.buckets[over:]
+	}
+	return closed
+}
+
+// Finish marks the session as ended and closes any open buckets.
+func (s *Session) Finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.endedAt.IsZero() {
+		return
+	}
+	s.closeBuckets(math.MaxInt64)
+	if run, ok := s.detector.flush(); ok {
+		s.addOutages(s.outageFromRun(run))
+	}
+	if !s.complete && len(s.samples) > 0 {
+		s.finish()
+	}
+	for _, run := range s.channels {
+		if !run.complete {
+			run.finish()
+		}
+	}
+	s.endedAt = time.Now()
+}
+
+// Complete reports whether the client has reported its final samples, i.e.
+// the test itself is over even if the connection is still open.
+func (s *Session) Complete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.complete
+}
+
+// Ended reports whether the session has finished and when.
+func (s *Session) Ended() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endedAt, !s.endedAt.IsZero()
+}
+
+// TimeSeries returns a copy of the closed buckets.
+func (s *Session) TimeSeries() []Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Bucket, len(s.buckets))
+	copy(out, s.buckets)
+	return out
+}
+
+// Outages returns a copy of the recorded outage events.
+func (s *Session) Outages() []Outage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Outage, len(s.outages))
+	copy(out, s.outages)
+	return out
+}
+
+// Playout simulates fixed and adaptive jitter buffers of the given depths
+// (milliseconds) over the recorded samples.
+func (s *Session) Playout(depths []float64) []metrics.PlayoutResult {
+	s.mu.Lock()
+	samples := make([]metrics.Sample, len(s.samples))
+	copy(samples, s.samples)
+	s.mu.Unlock()
+
+	return metrics.SimulatePlayout(samples, depths)
+}
+
+// SetResult records the latest outcome of an additional test mode.
+func (s *Session) SetResult(mode string, result interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.results == nil {
+		s.results = make(map[string]interface{})
+	}
+	s.results[mode] = result
+}
+
+// Summary returns the JSON view of the session.
+func (s *Session) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := Summary{
+		ID:        s.ID,
+		Transport: s.Transport,
+		StartedAt: s.StartedAt,
+		Report:    s.report,
+		Outages:   append([]Outage{}, s.outages...),
+	}
+	if len(s.interruptions) > 0 {
+		summary.Interruptions = append([]Interruption{}, s.interruptions...)
+	}
+	if len(s.states) > 0 {
+		summary.States = append([]StateChange{}, s.states...)
+	}
+	if !s.endedAt.IsZero() {
+		ended := s.endedAt
+		summary.EndedAt = &ended
+	}
+	if len(s.results) > 0 {
+		summary.Results = make(map[string]interface{}, len(s.results))
+		for mode, result := range s.results {
+			summary.Results[mode] = result
+		}
+	}
+	return summary
+}
+
+func newID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,29 @@
+package session
+
+import "time"
+
+// maxStateChanges bounds the state transitions kept per session.
+const maxStateChanges = 1000
+
+// StateChange is a transition of the session's PeerConnection state.
+type StateChange struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+// AddStateChange records a connection state transition.
+func (s *Session) AddStateChange(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states = append(s.states, StateChange{State: state, At: time.Now()})
+	if over := len(s.states) - maxStateChanges; over > 0 {
+		s.states = s.states[over:]
+	}
+}
+
+// StateChanges returns the connection state transitions recorded so far.
+func (s *Session) StateChanges() []StateChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StateChange{}, s.states...)
+}
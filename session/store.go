@@ -0,0 +1,292 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetention   = 24 * time.Hour
+	defaultBucketWidth = time.Second
+	// maxFinishedSessions bounds how many ended sessions are retained.
+	maxFinishedSessions = 256
+	// maxPlayoutDepths bounds the buffer depths simulated per request.
+	maxPlayoutDepths = 32
+	// maxPlayoutDepth is the deepest jitter buffer simulated, in milliseconds.
+	maxPlayoutDepth = 10000
+)
+
+// Options configures a Store. Zero values select the defaults.
+type Options struct {
+	Retention       time.Duration // how long ended sessions are kept (24h)
+	BucketWidth     time.Duration // time series bucket width (1s)
+	OutageThreshold time.Duration // shortest loss run recorded as an outage (200ms)
+}
+
+// Store keeps live and recently finished sessions in memory.
+type Store struct {
+	opts Options
+
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewStore constructs a session store.
+func NewStore(opts Options) *Store {
+	if opts.Retention <= 0 {
+		opts.Retention = defaultRetention
+	}
+	if opts.BucketWidth <= 0 {
+		opts.BucketWidth = defaultBucketWidth
+	}
+	if opts.OutageThreshold <= 0 {
+		opts.OutageThreshold = defaultOutageThreshold
+	}
+	return &Store{
+		opts:     opts,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create registers a new session whose probes travel over transport.
+func (st *Store) Create(transport string) *Session {
+	s := newSession(transport, st.opts)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pruneLocked()
+	st.sessions[s.ID] = s
+	return s
+}
+
+// Get looks up a session by ID.
+func (st *Store) Get(id string) (*Session, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	s, ok := st.sessions[id]
+	return s, ok
+}
+
+// List returns every retained session.
+func (st *Store) List() []*Session {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make([]*Session, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		out = append(out, s)
+	}
+	return out
+}
+
+// FinishAll finishes every session that is still running, flushing its open
+// time series buckets and outages into the results.
+func (st *Store) FinishAll() {
+	for _, s := range st.List() {
+		s.Finish()
+	}
+}
+
+// archiveRecord is one line of a session archive.
+type archiveRecord struct {
+	Summary
+	TimeSeries []Bucket `json:"timeseries"`
+}
+
+// AppendArchive appends every retained session to path as JSON lines, one
+// summary with its time series per line, so results survive a restart. It
+// returns the number of sessions written.
+func (st *Store) AppendArchive(path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open session archive: %w", err)
+	}
+	defer f.Close()
+
+	sessions := st.List()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.Before(sessions[j].StartedAt) })
+	enc := json.NewEncoder(f)
+	for i, s := range sessions {
+		if err := enc.Encode(archiveRecord{Summary: s.Summary(), TimeSeries: s.TimeSeries()}); err != nil {
+			return i, fmt.Errorf("failed to write session archive: %w", err)
+		}
+	}
+	return len(sessions), f.Close()
+}
+
+// pruneLocked drops sessions that ended longer ago than the retention period,
+// and the oldest finished sessions beyond maxFinishedSessions.
+func (st *Store) pruneLocked() {
+	cutoff := time.Now().Add(-st.opts.Retention)
+	var finished []*Session
+	for id, s := range st.sessions {
+		ended, ok := s.Ended()
+		if !ok {
+			continue
+		}
+		if ended.Before(cutoff) {
+			delete(st.sessions, id)
+			continue
+		}
+		finished = append(finished, s)
+	}
+
+	for len(finished) > maxFinishedSessions {
+		oldest := 0
+		for i, s := range finished {
+			if s.StartedAt.Before(finished[oldest].StartedAt) {
+				oldest = i
+			}
+		}
+		delete(st.sessions, finished[oldest].ID)
+		finished = append(finished[:oldest], finished[oldest+1:]...)
+	}
+}
+
+// Handler serves the session API under /api/sessions/:
+//
+//	GET /api/sessions/{id}             session summary and report
+//	GET /api/sessions/{id}/timeseries  closed time series buckets
+//	GET /api/sessions/{id}/outages     outage events
+//	GET /api/sessions/{id}/interruptions  signaling and ICE interruptions
+//	GET /api/sessions/{id}/playout     jitter buffer simulation (?depths=20,40,60)
+func (st *Store) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, view, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions"), "/"), "/")
+		s, ok := st.Get(id)
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		var body interface{}
+		switch view {
+		case "":
+			body = s.Summary()
+		case "timeseries":
+			body = s.TimeSeries()
+		case "outages":
+			body = s.Outages()
+		case "interruptions":
+			body = s.Interruptions()
+		case "playout":
+			depths, err := parseDepths(r.URL.Query().Get("depths"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = s.Playout(depths)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		writeJSON(w, body)
+	}
+}
+
+// parseDepths parses a comma separated list of buffer depths in milliseconds.
+func parseDepths(raw string) ([]float64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxPlayoutDepths {
+		return nil, fmt.Errorf("at most %d depths allowed", maxPlayoutDepths)
+	}
+	depths := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		// The range check also rejects NaN and infinities.
+		depth, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || !(depth >= 0 && depth <= maxPlayoutDepth) {
+			return nil, fmt.Errorf("invalid depth %q, want 0 to %d ms", part, maxPlayoutDepth)
+		}
+		depths = append(depths, depth)
+	}
+	return depths, nil
+}
+
+// CompareHandler serves GET /api/compare?ids=a,b, the summaries of several
+// sessions side by side, e.g. the DataChannel and WebSocket runs of one test.
+func (st *Store) CompareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		summaries := []Summary{}
+		for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			s, ok := st.Get(strings.TrimSpace(id))
+			if !ok {
+				http.Error(w, fmt.Sprintf("session %q not found", id), http.StatusNotFound)
+				return
+			}
+			summaries = append(summaries, s.Summary())
+		}
+		writeJSON(w, summaries)
+	}
+}
+
+// OutageSummary aggregates outage events across every retained session.
+type OutageSummary struct {
+	Sessions      int      `json:"sessions"`
+	Affected      int      `json:"affected"` // sessions with at least one outage
+	Count         int      `json:"count"`
+	TotalDuration float64  `json:"totalDuration"` // milliseconds
+	MaxDuration   float64  `json:"maxDuration"`   // milliseconds
+	PacketsLost   int      `json:"packetsLost"`
+	Events        []Outage `json:"events"`
+}
+
+// OutagesHandler serves GET /api/outages, the outage events of every
+// retained session, newest first. Session IDs are the handles to full
+// results, so events are not attributed to their sessions.
+func (st *Store) OutagesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sessions := st.List()
+		summary := OutageSummary{Sessions: len(sessions), Events: []Outage{}}
+		for _, s := range sessions {
+			outages := s.Outages()
+			if len(outages) > 0 {
+				summary.Affected++
+			}
+			for _, o := range outages {
+				summary.Count++
+				summary.TotalDuration += o.Duration
+				summary.PacketsLost += o.PacketsLost
+				if o.Duration > summary.MaxDuration {
+					summary.MaxDuration = o.Duration
+				}
+				summary.Events = append(summary.Events, o)
+			}
+		}
+		sort.Slice(summary.Events, func(i, j int) bool {
+			return summary.Events[i].Start.After(summary.Events[j].Start)
+		})
+
+		writeJSON(w, summary)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(body)
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"pltester/impair"
 )
 
 const (
@@ -16,8 +18,41 @@ const (
 	maxUploadSizeMB       = 100.0
 )
 
+// impairment is applied to every speedtest request. Only delay, jitter and
+// the rate limit affect HTTP; loss and reordering belong to the echo path.
+var impairment impair.Config
+
+// SetImpairment configures the emulated path for speedtest requests.
+func SetImpairment(cfg impair.Config) {
+	impairment = cfg
+}
+
+// ProtocolHeader carries the HTTP version a speedtest request was served
+// over, so clients can compare TCP and QUIC on their path.
+const ProtocolHeader = "X-Protocol"
+
 type uploadResponse struct {
-	ReceivedBytes int64 `json:"receivedBytes"`
+	ReceivedBytes int64  `json:"receivedBytes"`
+	Protocol      string `json:"protocol"`
+}
+
+type pingResponse struct {
+	OK       bool   `json:"ok"`
+	Protocol string `json:"protocol"`
+}
+
+// Protocol names the HTTP version of r with its ALPN identifier ("http/1.1",
+// "h2" or "h3"), matching the browser's PerformanceResourceTiming
+// nextHopProtocol.
+func Protocol(r *http.Request) string {
+	switch r.ProtoMajor {
+	case 3:
+		return "h3"
+	case 2:
+		return "h2"
+	default:
+		return "http/1.1"
+	}
 }
 
 // DownloadHandler streamed random payload to benchmark downstream throughput.
@@ -45,9 +80,12 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 	if sizeBytes < 1 {
 		sizeBytes = 1
 	}
+	impair.Pause(impairment)
+	w.Header().Set(ProtocolHeader, Protocol(r))
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.FormatInt(sizeBytes, 10))
 	w.Header().Set("Cache-Control", "no-store")
+	out := impair.NewWriter(w, impairment)
 
 	randSrc := rand.New(rand.NewSource(time.Now().UnixNano()))
 	buf := make([]byte, 64*1024)
@@ -62,7 +100,7 @@ func DownloadHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "failed to generate payload", http.StatusInternalServerError)
 			return
 		}
-		if _, err := w.Write(buf[:chunk]); err != nil {
+		if _, err := out.Write(buf[:chunk]); err != nil {
 			return
 		}
 		remaining -= int64(chunk)
@@ -80,7 +118,8 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	reader := http.MaxBytesReader(w, r.Body, maxBytes)
 	defer reader.Close()
 
-	received, err := io.Copy(io.Discard, reader)
+	impair.Pause(impairment)
+	received, err := io.Copy(io.Discard, impair.NewReader(reader, impairment))
 	if err != nil {
 		var maxErr *http.MaxBytesError
 		if errors.As(err, &maxErr) {
@@ -95,9 +134,10 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set(ProtocolHeader, Protocol(r))
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-store")
-	json.NewEncoder(w).Encode(uploadResponse{ReceivedBytes: received})
+	json.NewEncoder(w).Encode(uploadResponse{ReceivedBytes: received, Protocol: Protocol(r)})
 }
 
 // PingHandler returns a lightweight JSON response for latency measurements.
@@ -107,7 +147,9 @@ func PingHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	impair.Pause(impairment)
+	w.Header().Set(ProtocolHeader, Protocol(r))
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-store, no-cache")
-	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	json.NewEncoder(w).Encode(pingResponse{OK: true, Protocol: Protocol(r)})
 }
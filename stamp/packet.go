@@ -0,0 +1,104 @@
+package stamp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// DefaultPort is the IANA-assigned STAMP and TWAMP test port.
+const DefaultPort = 862
+
+// PacketSize is the length of an unauthenticated STAMP test packet. TWAMP-Light
+// test packets use the same layout (RFC 8762 section 4.6).
+const PacketSize = 44
+
+// MinTWAMPLightSize is the shortest unauthenticated TWAMP-Light test packet
+// the reflector accepts (RFC 5357 section 4.2.1). Replies to shorter packets
+// are zero padded to PacketSize.
+const MinTWAMPLightSize = 41
+
+// errorEstimate marks timestamps as NTP format, not externally synchronised,
+// with a multiplier of one (RFC 4656 section 4.1.2).
+const errorEstimate = 0x0001
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 and 1970-01-01.
+const ntpEpochOffset = 2208988800
+
+var errShortPacket = errors.New("stamp: packet shorter than 44 bytes")
+
+// SenderPacket is the unauthenticated Session-Sender test packet
+// (RFC 8762 section 4.2.1).
+type SenderPacket struct {
+	Seq       uint32
+	Timestamp time.Time
+}
+
+// Marshal encodes p into a buffer of size bytes, zero padded. size is raised
+// to PacketSize if smaller.
+func (p SenderPacket) Marshal(size int) []byte {
+	if size < PacketSize {
+		size = PacketSize
+	}
+	b := make([]byte, size)
+	binary.BigEndian.PutUint32(b[0:4], p.Seq)
+	binary.BigEndian.PutUint64(b[4:12], toNTP(p.Timestamp))
+	binary.BigEndian.PutUint16(b[12:14], errorEstimate)
+	return b
+}
+
+// ReflectorPacket is the unauthenticated Session-Reflector test packet
+// (RFC 8762 section 4.3.1).
+type ReflectorPacket struct {
+	Seq             uint32
+	Timestamp       time.Time // reflector transmit time
+	ReceiveTime     time.Time // reflector receive time
+	SenderSeq       uint32
+	SenderTimestamp time.Time
+	SenderTTL       uint8
+}
+
+// ParseReflectorPacket decodes a Session-Reflector test packet.
+func ParseReflectorPacket(b []byte) (ReflectorPacket, error) {
+	if len(b) < PacketSize {
+		return ReflectorPacket{}, errShortPacket
+	}
+	return ReflectorPacket{
+		Seq:             binary.BigEndian.Uint32(b[0:4]),
+		Timestamp:       fromNTP(binary.BigEndian.Uint64(b[4:12])),
+		ReceiveTime:     fromNTP(binary.BigEndian.Uint64(b[16:24])),
+		SenderSeq:       binary.BigEndian.Uint32(b[24:28]),
+		SenderTimestamp: fromNTP(binary.BigEndian.Uint64(b[28:36])),
+		SenderTTL:       b[40],
+	}, nil
+}
+
+// reflect turns a Session-Sender packet into the Session-Reflector reply in
+// place. The reply keeps the sender's length and padding, so the reflector
+// never amplifies traffic. Sequence numbers are copied from the sender as in
+// stateless mode (RFC 8762 section 4.3).
+func reflect(b []byte, received, transmit time.Time, ttl uint8) {
+	var senderFields [14]byte
+	copy(senderFields[:], b[0:14])
+
+	binary.BigEndian.PutUint64(b[4:12], toNTP(transmit))
+	binary.BigEndian.PutUint16(b[12:14], errorEstimate)
+	binary.BigEndian.PutUint16(b[14:16], 0)
+	binary.BigEndian.PutUint64(b[16:24], toNTP(received))
+	copy(b[24:38], senderFields[:])
+	binary.BigEndian.PutUint16(b[38:40], 0)
+	b[40] = ttl
+	b[41], b[42], b[43] = 0, 0, 0
+}
+
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := (v & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nanos))
+}
@@ -0,0 +1,98 @@
+package stamp
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// maxPacketSize bounds the test packets the reflector accepts.
+const maxPacketSize = 9000
+
+// readErrorBackoff is the pause after a failed read, so a persistent error
+// does not spin the reflector.
+const readErrorBackoff = 10 * time.Millisecond
+
+// Reflector answers STAMP and TWAMP-Light test packets on a UDP socket.
+type Reflector struct {
+	conn *net.UDPConn
+}
+
+// Listen opens a reflector on addr, e.g. ":862".
+func Listen(addr string) (*Reflector, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Session-Sender TTL is copied from the received packet; not every
+	// platform reports it, in which case the field stays zero.
+	ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagTTL, true)
+	ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagHopLimit, true)
+
+	return &Reflector{conn: conn}, nil
+}
+
+// Addr returns the local address the reflector is bound to.
+func (r *Reflector) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Serve reflects test packets until the reflector is closed. Other read
+// errors, such as ICMP errors reported for an earlier reply, are logged and
+// the reflector keeps serving.
+func (r *Reflector) Serve() error {
+	buf := make([]byte, maxPacketSize)
+	oob := make([]byte, len(ipv4.NewControlMessage(ipv4.FlagTTL))+len(ipv6.NewControlMessage(ipv6.FlagHopLimit)))
+
+	for {
+		n, oobn, _, peer, err := r.conn.ReadMsgUDP(buf, oob)
+		received := time.Now()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("STAMP reflector read failed: %v", err)
+			time.Sleep(readErrorBackoff)
+			continue
+		}
+		if n < MinTWAMPLightSize || peer.Port == 0 {
+			continue
+		}
+
+		if n < PacketSize {
+			clear(buf[n:PacketSize])
+			n = PacketSize
+		}
+		packet := buf[:n]
+		reflect(packet, received, time.Now(), receivedTTL(oob[:oobn]))
+		if _, err := r.conn.WriteToUDP(packet, peer); err != nil {
+			log.Printf("STAMP reflector failed to reply to %s: %v", peer, err)
+		}
+	}
+}
+
+// Close stops the reflector.
+func (r *Reflector) Close() error {
+	return r.conn.Close()
+}
+
+func receivedTTL(oob []byte) uint8 {
+	var cm4 ipv4.ControlMessage
+	if cm4.Parse(oob) == nil && cm4.TTL > 0 {
+		return uint8(cm4.TTL)
+	}
+	var cm6 ipv6.ControlMessage
+	if cm6.Parse(oob) == nil && cm6.HopLimit > 0 {
+		return uint8(cm6.HopLimit)
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+package stamp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"pltester/metrics"
+)
+
+const (
+	defaultCount    = 100
+	defaultInterval = 20 * time.Millisecond
+	defaultTimeout  = 2 * time.Second
+)
+
+// Options configures a Session-Sender test run. Zero values select the
+// defaults of 100 packets, 20ms apart, waiting 2s for late replies.
+type Options struct {
+	Count    int
+	Interval time.Duration
+	Size     int // test packet size in bytes, at least PacketSize
+	Timeout  time.Duration
+}
+
+// Result holds the metrics of one test run. Forward and Backward use the
+// sender's and reflector's clocks as they are, so their absolute delays are
+// only meaningful when both clocks are synchronised; the variation and
+// reordering metrics are valid either way.
+type Result struct {
+	RoundTrip metrics.Report `json:"roundTrip"` // reflector residence time removed
+	Forward   metrics.Report `json:"forward"`   // sender to reflector
+	Backward  metrics.Report `json:"backward"`  // reflector to sender
+}
+
+type reply struct {
+	packet  ReflectorPacket
+	arrival time.Time
+}
+
+// Run sends test packets to a STAMP or TWAMP-Light reflector at target and
+// collects the replies.
+func Run(ctx context.Context, target string, opts Options) (Result, error) {
+	if opts.Count <= 0 {
+		opts.Count = defaultCount
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	var replies []reply
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, err := conn.Read(buf)
+			arrival := time.Now()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				// ICMP unreachable surfaces as a read error; keep listening.
+				continue
+			}
+			packet, err := ParseReflectorPacket(buf[:n])
+			if err != nil {
+				continue
+			}
+			mu.Lock()
+			replies = append(replies, reply{packet: packet, arrival: arrival})
+			mu.Unlock()
+		}
+	}()
+
+	sent := make([]time.Time, 0, opts.Count)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for seq := 0; seq < opts.Count; seq++ {
+		now := time.Now()
+		packet := SenderPacket{Seq: uint32(seq), Timestamp: now}.Marshal(opts.Size)
+		sent = append(sent, now)
+		conn.Write(packet)
+
+		if seq == opts.Count-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			<-done
+			return Result{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(opts.Timeout):
+	}
+	conn.Close()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	return buildResult(sent, replies), nil
+}
+
+func buildResult(sent []time.Time, replies []reply) Result {
+	answered := make([]bool, len(sent))
+	var roundTrip, forward, backward []metrics.Sample
+	for _, r := range replies {
+		seq := int(r.packet.SenderSeq)
+		if seq >= len(sent) {
+			continue
+		}
+		answered[seq] = true
+
+		t1, t2, t3, t4 := sent[seq], r.packet.ReceiveTime, r.packet.Timestamp, r.arrival
+		residence := t3.Sub(t2)
+		roundTrip = append(roundTrip, metrics.Sample{Seq: uint64(seq), Sent: millis(t1), Received: millis(t4.Add(-residence))})
+		forward = append(forward, metrics.Sample{Seq: uint64(seq), Sent: millis(t1), Received: millis(t2)})
+		backward = append(backward, metrics.Sample{Seq: uint64(seq), Sent: millis(t3), Received: millis(t4)})
+	}
+
+	for seq, ok := range answered {
+		if ok {
+			continue
+		}
+		lost := metrics.Sample{Seq: uint64(seq), Sent: millis(sent[seq]), Lost: true}
+		roundTrip = append(roundTrip, lost)
+		forward = append(forward, lost)
+		backward = append(backward, lost)
+	}
+
+	return Result{
+		RoundTrip: metrics.Compute(roundTrip),
+		Forward:   metrics.Compute(forward),
+		Backward:  metrics.Compute(backward),
+	}
+}
+
+func millis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
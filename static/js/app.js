@@ -11,92 +11,255 @@ let packetCount = 0;
 let isStressMode = false; // 压测模式标志
 let stressStartTime = 0; // 压测模式开始时间
 
-let latencyStats = {
-    min: Infinity,
-    max: -Infinity,
-    sum: 0,
-    count: 0,
-    values: [],        // 存储所有延迟值用于计算百分位
-    lastLatency: null, // 上一个延迟值，用于计算抖动
-    jitterSum: 0,      // 抖动累计值
-    jitterCount: 0     // 抖动样本数
+// 探测包超过该时间未收到回显即视为丢失（毫秒）
+const PROBE_TIMEOUT_MS = 3000;
+const PROBE_FRAME_BYTES = 12; // 二进制探测包：uint32 序号 + float64 发送时间
+let signalingWs; // 信令 WebSocket
+let reportCursor = 0; // 下一个待上报的探测包序号
+let sessionId = null; // 服务端会话ID
+let timeSeries = []; // 服务端推送的时间序列桶
+let outages = []; // 服务端检测到的连接中断事件
+let pendingPlayout = false; // 等待最终结果后查询抖动缓冲模拟
+const DEFAULT_JITTER_BUFFER_MS = 60;
+const DATACHANNEL_CONNECT_TIMEOUT_MS = 10000; // 超时未建立数据通道则改用 WebSocket 探测
+let connectTimeoutId;
+let tcpProbe = null; // WebSocket (TCP) 探测通道
+let quicProbe = null; // QUIC (WebTransport) 探测通道
+let mediaStream = null; // RTP 媒体流测试的合成音视频
+let channelComparison = null; // DataChannel 可靠性对比
+let throughputTest = null; // DataChannel 吞吐量测试，可能在探测结束后继续运行，随连接关闭
+let natReport = null; // 服务端根据内置 STUN 服务器判断的 NAT 行为
+let dualStackProbes = []; // IPv4 / IPv6 双栈对比会话
+let testRunning = false; // 测试进行中，信令意外断开时尝试恢复会话
+let resumeToken = null; // 服务端下发的会话恢复令牌
+let interruptions = []; // 会话经历并恢复的信令 / ICE 中断
+let iceRestartTimer = null;
+const SIGNALING_RESUME_WINDOW_MS = 30000; // 与服务端默认的恢复宽限期一致
+const SIGNALING_RETRY_MS = 1000;
+const ICE_RESTART_DELAY_MS = 2000; // ICE disconnected 可能自行恢复，超过该时间仍未恢复再重启
+let closeReason = null; // 服务端主动结束会话的原因
+const CLOSE_REASON_LABELS = {
+    'failed': '连接失败',
+    'negotiation-timeout': '超时未建立连接',
+    'idle': '长时间无流量',
+    'shutdown': '节点关闭',
 };
+const DRAIN_MARGIN_MS = 3000; // 节点关闭前预留给最终结果上报的时间
 
 // 重置统计数据
 function resetLatencyStats() {
-    latencyStats = {
-        min: Infinity,
-        max: -Infinity,
-        sum: 0,
-        count: 0,
-        values: [],
-        lastLatency: null,
-        jitterSum: 0,
-        jitterCount: 0
-    };
-    
+    reportCursor = 0;
+    timeSeries = [];
+    outages = [];
+    interruptions = [];
+
     // 重置显示
     document.getElementById('avg-latency').innerText = '-';
     document.getElementById('min-latency').innerText = '-';
     document.getElementById('max-latency').innerText = '-';
     document.getElementById('p90-latency').innerText = '-';
     document.getElementById('jitter').innerText = '-';
+    document.getElementById('outages').innerText = '0';
+    document.getElementById('interruptions').innerText = '0';
+    document.getElementById('effective-loss').innerText = '-';
 }
 
-// 更新延迟统计
-let statsUpdatePending = false;
-function updateLatencyStats(latency) {
-    latencyStats.sum += latency;
-    latencyStats.count++;
-    latencyStats.values.push(latency);
-    
-    // 计算抖动(Jitter)：当前延迟与上一个延迟的差值的绝对值
-    // 这是网络抖动的标准定义：连续数据包延迟的变化量
-    if (latencyStats.lastLatency !== null) {
-        const jitter = Math.abs(latency - latencyStats.lastLatency);
-        latencyStats.jitterSum += jitter;
-        latencyStats.jitterCount++;
-    }
-    latencyStats.lastLatency = latency;
-    
-    // 只保留最近2000个样本用于计算百分位（避免内存占用过大）
-    if (latencyStats.values.length > 2000) {
-        latencyStats.values.shift();
+// 显示 WebSocket (TCP) 对比结果
+const renderTcpReport = (report) => {
+    document.getElementById('tcp-loss-rate').innerText = (report.lossRatio * 100).toFixed(2) + '%';
+    if (report.latency.count > 0) {
+        document.getElementById('tcp-avg-latency').innerText = report.latency.mean.toFixed(3);
+        document.getElementById('tcp-p90-latency').innerText = report.latency.p90.toFixed(3);
+        document.getElementById('tcp-max-latency').innerText = report.latency.max.toFixed(3);
+        document.getElementById('tcp-jitter').innerText = report.jitter.toFixed(3);
     }
-    
-    if (latency < latencyStats.min) latencyStats.min = latency;
-    if (latency > latencyStats.max) latencyStats.max = latency;
-    
-    if (!statsUpdatePending) {
-        statsUpdatePending = true;
-        requestAnimationFrame(() => {
-            // 计算平均延迟
-            const avg = latencyStats.sum / latencyStats.count;
-            
-            // 计算平均抖动
-            const avgJitter = latencyStats.jitterCount > 0 
-                ? latencyStats.jitterSum / latencyStats.jitterCount 
-                : 0;
-            
-            // 计算90百分位（前10%最高延迟的起始值）
-            let p90 = 0;
-            if (latencyStats.values.length > 0) {
-                const sorted = [...latencyStats.values].sort((a, b) => a - b);
-                const p90Index = Math.floor(sorted.length * 0.9);
-                p90 = sorted[p90Index];
+};
+
+// 启动 WebSocket (TCP) 探测
+const startTcpProbe = (frequency, onReport) => {
+    tcpProbe = new WebSocketProbe(document.getElementById('testNode').value, {
+        frequency,
+        timeoutMs: PROBE_TIMEOUT_MS,
+        onSession: (id) => console.log(`WebSocket 探测会话ID: ${id}`),
+        onReport,
+    });
+    tcpProbe.start();
+};
+
+// 显示 QUIC 数据报对比结果
+const renderQuicReport = (report) => {
+    document.getElementById('quic-loss-rate').innerText = (report.lossRatio * 100).toFixed(2) + '%';
+    if (report.latency.count > 0) {
+        document.getElementById('quic-avg-latency').innerText = report.latency.mean.toFixed(3);
+        document.getElementById('quic-p90-latency').innerText = report.latency.p90.toFixed(3);
+        document.getElementById('quic-max-latency').innerText = report.latency.max.toFixed(3);
+        document.getElementById('quic-jitter').innerText = report.jitter.toFixed(3);
+    }
+};
+
+// 启动 QUIC (WebTransport) 探测，浏览器不支持或节点未启用 HTTP/3 时在结果处提示
+const startQuicProbe = (frequency, size) => {
+    if (!QUICProbe.supported()) {
+        document.getElementById('quic-loss-rate').innerText = '浏览器不支持 WebTransport';
+        return;
+    }
+    quicProbe = new QUICProbe(document.getElementById('testNode').value, {
+        frequency,
+        size,
+        timeoutMs: PROBE_TIMEOUT_MS,
+        onSession: (id) => console.log(`QUIC 探测会话ID: ${id}`),
+        onReport: renderQuicReport,
+        onError: (err) => {
+            console.warn('QUIC 探测无法连接:', err);
+            document.getElementById('quic-loss-rate').innerText = '无法连接（节点需启用 HTTPS 和 HTTP/3）';
+        },
+    });
+    quicProbe.start();
+};
+
+const TRANSPORT_POLICY_LABELS = { udp: '仅 UDP', tcp: '仅 TCP', relay: '仅 TURN 中继' };
+
+// 主测试信令地址，transport 参数要求服务端只用指定传输方式的候选
+const signalingUrl = (transport) => {
+    const url = new URL(document.getElementById('testNode').value, window.location.href);
+    // 相对地址（如 /ws）解析为页面的 http(s) 地址，HTTPS 页面须使用 wss
+    if (url.protocol === 'https:') {
+        url.protocol = 'wss:';
+    } else if (url.protocol === 'http:') {
+        url.protocol = 'ws:';
+    }
+    if (transport) {
+        url.searchParams.set('transport', transport);
+    }
+    return url.toString();
+};
+
+// 显示服务端报告的 ICE 选中路径
+const renderTransportPath = (path) => {
+    const typeLabels = { host: '直连', srflx: 'NAT 反射', prflx: 'NAT 反射', relay: 'TURN 中继' };
+    document.getElementById('transport-path').innerText =
+        `${path.protocol.toUpperCase()} ${path.remote} → ${path.local}（客户端 ${typeLabels[path.remoteType] || path.remoteType} / 服务端 ${typeLabels[path.localType] || path.localType}）`;
+};
+
+// WebRTC 无法建立数据通道时，改用 WebSocket (TCP) 探测
+const fallbackToWebSocket = (frequency, duration) => {
+    console.warn('数据通道未能建立，改用 WebSocket (TCP) 探测');
+    const transport = document.getElementById('transport-policy').value;
+    if (transport) {
+        const hint = transport === 'udp' ? '，客户端网络可能阻断 UDP' : '';
+        setStatus(`${TRANSPORT_POLICY_LABELS[transport]} 策略无法建立连接${hint}，改用 WebSocket (TCP) 测试中...`);
+    } else if (natReport && !natReport.mappedAddresses) {
+        setStatus('WebRTC 无法连接（未收到 STUN 响应，UDP 可能被阻断），改用 WebSocket (TCP) 测试中...');
+    } else if (natReport && natReport.needsTurn) {
+        setStatus('WebRTC 无法连接（NAT 为对称型，需要 TURN 中继），改用 WebSocket (TCP) 测试中...');
+    } else {
+        setStatus('WebRTC 无法连接，改用 WebSocket (TCP) 测试中...');
+    }
+    if (pc) {
+        pc.close();
+    }
+    startTcpProbe(frequency, (report) => {
+        document.getElementById('sent-packets').innerText = report.sent;
+        document.getElementById('received-packets').innerText = report.received;
+        renderReport(report);
+    });
+    if (!isStressMode) {
+        durationTimeoutId = setTimeout(() => {
+            stopTest();
+        }, duration * 1000);
+    }
+};
+
+// 当前预设的抖动缓冲深度（毫秒）
+const currentJitterBuffer = () => {
+    const preset = presetsData[document.getElementById('preset').value];
+    return (preset && preset.jitterBuffer) || DEFAULT_JITTER_BUFFER_MS;
+};
+
+// 节点 HTTP API 地址（与测试节点同源）
+const nodeApiUrl = (path) => {
+    const url = new URL(document.getElementById('testNode').value, window.location.href);
+    if (url.protocol === 'wss:') {
+        url.protocol = 'https:';
+    } else if (url.protocol === 'ws:') {
+        url.protocol = 'http:';
+    }
+    url.pathname = path;
+    url.search = '';
+    return url.toString();
+};
+
+// 会话 API 地址
+const sessionApiUrl = (path) => nodeApiUrl(`/api/sessions/${sessionId}${path}`);
+
+// 获取节点推荐的 ICE 服务器（启用内置 STUN 时为节点自身），旧版节点不支持时使用公共 STUN
+const fetchIceServers = async () => {
+    try {
+        const response = await fetch(nodeApiUrl('/api/ice'));
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}`);
+        }
+        return (await response.json()).iceServers;
+    } catch (error) {
+        console.warn('获取 ICE 服务器失败，使用公共 STUN:', error);
+        return [{ urls: ['stun:stun.l.google.com:19302', 'stun:stun1.l.google.com:19302'] }];
+    }
+};
+
+// 查询抖动缓冲模拟结果：迟到的包对实时应用等同于丢失
+const fetchPlayout = async () => {
+    const depth = currentJitterBuffer();
+    document.getElementById('playout-depth').innerText = depth;
+    try {
+        const response = await fetch(sessionApiUrl(`/playout?depths=${depth}`));
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}`);
+        }
+        const results = await response.json();
+        const fixed = results.find(r => r.mode === 'fixed');
+        const adaptive = results.find(r => r.mode === 'adaptive');
+        document.getElementById('effective-loss').innerText =
+            `固定 ${(fixed.effectiveLoss * 100).toFixed(2)}% / 自适应 ${(adaptive.effectiveLoss * 100).toFixed(2)}%`;
+    } catch (error) {
+        console.error('获取抖动缓冲模拟结果失败:', error);
+    }
+};
+
+// 上报已完成（收到回显或超时）的探测包，由服务端统一计算指标
+const flushSamples = (final = false) => {
+    if (!signalingWs || signalingWs.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    const cutoff = performance.now() - PROBE_TIMEOUT_MS;
+    const samples = [];
+    while (reportCursor < packetCount) {
+        const entry = sentPacketTimes[reportCursor];
+        if (entry) {
+            if (!entry.received && !final && entry.sentTime > cutoff) {
+                break;
             }
-            
-            // 批量更新UI（高精度显示，保留3位小数）
-            document.getElementById('avg-latency').innerText = avg.toFixed(3);
-            document.getElementById('min-latency').innerText = latencyStats.min.toFixed(3);
-            document.getElementById('max-latency').innerText = latencyStats.max.toFixed(3);
-            document.getElementById('p90-latency').innerText = p90.toFixed(3);
-            document.getElementById('jitter').innerText = avgJitter.toFixed(3);
-            
-            statsUpdatePending = false;
-        });
+            samples.push(entry.received
+                ? { seq: reportCursor, sent: entry.sentTime, received: entry.receivedTime }
+                : { seq: reportCursor, sent: entry.sentTime, lost: true });
+        }
+        reportCursor++;
     }
-}
+    if (samples.length > 0 || final) {
+        signalingWs.send(JSON.stringify({ type: 'samples', samples, final }));
+    }
+};
+
+// 显示服务端计算的结果（RFC 3550 抖动等标准定义）
+const renderReport = (report) => {
+    if (report.latency.count > 0) {
+        document.getElementById('avg-latency').innerText = report.latency.mean.toFixed(3);
+        document.getElementById('min-latency').innerText = report.latency.min.toFixed(3);
+        document.getElementById('max-latency').innerText = report.latency.max.toFixed(3);
+        document.getElementById('p90-latency').innerText = report.latency.p90.toFixed(3);
+        document.getElementById('jitter').innerText = report.jitter.toFixed(3);
+    }
+    document.getElementById('packet-loss-rate').innerText = (report.lossRatio * 100).toFixed(2) + '%';
+};
 
 const frequency_input = document.getElementById('frequency');
 const size_input = document.getElementById('size');
@@ -302,6 +465,7 @@ const startSendingData = (frequency, size, totalPackets, duration) => {
     }
     chartUpdateIntervalId = setInterval(() => {
         updateChart();
+        flushSamples();
     }, 500);
     
     // 使用高精度定时器 - 预先计算时间戳避免在循环中重复调用
@@ -327,10 +491,11 @@ const startSendingData = (frequency, size, totalPackets, duration) => {
             
             // 使用当前时间戳而不是预计算的，确保精度
             const timestamp = performance.now();
-            const packet = `${packetCount},${timestamp}`;
+            probeView.setUint32(0, packetCount);
+            probeView.setFloat64(4, timestamp);
             
-            // 立即发送，减少缓冲
-            dataChannel.send(packet);
+            // 立即发送，减少缓冲（send 会复制数据，缓冲区可以复用）
+            dataChannel.send(probeBuffer);
             sentPacketTimes[packetCount] = { sentTime: timestamp, received: false };
             packetCount++;
             
@@ -368,8 +533,77 @@ const startSendingData = (frequency, size, totalPackets, duration) => {
     }
 };
 
+// ICE 重启：网络切换（如 Wi-Fi 换到蜂窝）后原候选对失效，以新的 ufrag/pwd 重新收集候选，
+// 数据通道和测试不中断。信令断开期间无法协商，待会话恢复后再重启
+const restartIce = async () => {
+    clearTimeout(iceRestartTimer);
+    if (!pc || pc.signalingState !== 'stable' || !signalingWs || signalingWs.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    console.log('发起 ICE 重启');
+    const offer = await pc.createOffer({ iceRestart: true });
+    await pc.setLocalDescription(offer);
+    signalingWs.send(JSON.stringify(pc.localDescription));
+};
+
+// 数据通道建立后才重启，尚未连接成功的失败由 WebSocket 回退处理
+const dataChannelOpen = () => testRunning && dataChannel && dataChannel.readyState === 'open';
+
+// 网络类型变化时主动重启 ICE，不必等到连接超时
+const onNetworkChange = () => {
+    if (dataChannelOpen()) {
+        restartIce();
+    }
+};
+window.addEventListener('online', onNetworkChange);
+if (navigator.connection) {
+    navigator.connection.addEventListener('change', onNetworkChange);
+}
+
+// 数据通道未建立时由 WebSocket (TCP) 探测接替（已开始或等待连接超时后开始），
+// 信令会话随后因连接失败或超时未建立连接被服务端结束，不影响测试
+const usingWebSocketFallback = () =>
+    (tcpProbe !== null || connectTimeoutId) && (!dataChannel || dataChannel.readyState !== 'open');
+
+// 信令断开：测试进行中时以恢复令牌重连，否则结束测试
+const handleSignalingClose = () => {
+    if (testRunning && usingWebSocketFallback()) {
+        return;
+    }
+    if (testRunning && resumeToken) {
+        reconnectSignaling(performance.now() + SIGNALING_RESUME_WINDOW_MS);
+        return;
+    }
+    stopTest();
+    setStatus(closeReason ? `连接关闭（${CLOSE_REASON_LABELS[closeReason] || closeReason}）` : '连接关闭');
+};
+
+// 在服务端宽限期内反复尝试重连，接管原有会话后 PeerConnection 和探测继续运行
+const reconnectSignaling = (deadline) => {
+    setStatus('信令连接中断，正在重连...');
+    const url = new URL(signalingUrl(''));
+    url.searchParams.set('resume', resumeToken);
+    const ws = new WebSocket(url.toString());
+    ws.onopen = () => {
+        signalingWs = ws;
+    };
+    ws.onmessage = handleWebSocketMessage;
+    ws.onclose = () => {
+        if (signalingWs === ws) {
+            handleSignalingClose();
+        } else if (testRunning && resumeToken && performance.now() < deadline) {
+            setTimeout(() => reconnectSignaling(deadline), SIGNALING_RETRY_MS);
+        } else {
+            stopTest();
+            setStatus('连接关闭');
+        }
+    };
+};
+
 // 停止测试函数
 const stopTest = () => {
+    testRunning = false;
+    clearTimeout(iceRestartTimer);
     if (intervalId) {
         clearInterval(intervalId);
         intervalId = null;
@@ -382,6 +616,28 @@ const stopTest = () => {
         clearTimeout(durationTimeoutId);
         durationTimeoutId = null;
     }
+    if (connectTimeoutId) {
+        clearTimeout(connectTimeoutId);
+        connectTimeoutId = null;
+    }
+    if (tcpProbe) {
+        tcpProbe.stop();
+        tcpProbe = null;
+    }
+    if (quicProbe) {
+        quicProbe.stop();
+        quicProbe = null;
+    }
+    if (mediaStream) {
+        mediaStream.stopSynthetic();
+        mediaStream = null;
+    }
+    if (channelComparison) {
+        channelComparison.stop();
+        channelComparison = null;
+    }
+    dualStackProbes.forEach(probe => probe.stop());
+    dualStackProbes = [];
     
     setStatus('测试完成');
     document.getElementById('start-btn').disabled = false;
@@ -396,11 +652,122 @@ const stopTest = () => {
         }
     }
     updateChart(); // 最后一次更新图表
+    if (signalingWs && signalingWs.readyState === WebSocket.OPEN && sessionId) {
+        pendingPlayout = true;
+    }
+    flushSamples(true);
 };
 
 const handleWebSocketMessage = async (event) => {
     const message = JSON.parse(event.data);
-    if (message.candidate) {
+    if (message.type === 'session') {
+        sessionId = message.id;
+        resumeToken = message.resumeToken || null;
+        console.log(`会话ID: ${sessionId}，时间序列: /api/sessions/${sessionId}/timeseries`);
+        if (message.stun) {
+            const ws = signalingWs;
+            discoverNat(message.stun).then(candidates => {
+                if (ws.readyState === WebSocket.OPEN) {
+                    ws.send(JSON.stringify({ type: 'nat', candidates }));
+                }
+            });
+        }
+    } else if (message.type === 'resume') {
+        if (!message.resumed) {
+            // 会话已超过宽限期结束，无法继续
+            resumeToken = null;
+            stopTest();
+            setStatus('会话已过期，连接关闭');
+            return;
+        }
+        console.log(`会话 ${message.id} 已恢复`);
+        setStatus(dataChannelOpen() ? '测试中...' : '信令已恢复');
+        if (pc && ['disconnected', 'failed'].includes(pc.iceConnectionState)) {
+            restartIce();
+        }
+    } else if (message.type === 'draining') {
+        // 节点即将关闭（如部署重启）：在截止时间前提前结束测试，保证最终结果上报成功
+        if (testRunning) {
+            const remainingMs = new Date(message.deadline).getTime() - Date.now() - DRAIN_MARGIN_MS;
+            console.warn(`节点即将关闭，测试将在 ${Math.max(0, remainingMs / 1000).toFixed(0)} 秒内结束`);
+            setStatus('节点即将关闭，测试将提前结束...');
+            clearTimeout(durationTimeoutId);
+            durationTimeoutId = setTimeout(stopTest, Math.max(0, remainingMs));
+        }
+    } else if (message.type === 'closed') {
+        // 服务端已结束会话（连接失败、超时未连接或长时间无流量），不再尝试恢复
+        closeReason = message.reason;
+        resumeToken = null;
+        console.warn(`服务端结束会话: ${CLOSE_REASON_LABELS[message.reason] || message.reason}`);
+    } else if (message.type === 'interruption') {
+        const i = message.interruption;
+        interruptions.push(i);
+        document.getElementById('interruptions').innerText = interruptions.length;
+        console.log(`${i.kind === 'ice' ? 'ICE' : '信令'}中断 ${i.duration.toFixed(0)} ms 后恢复${i.detail ? `（${i.detail}）` : ''}`);
+    } else if (message.type === 'transport') {
+        if (message.path) {
+            renderTransportPath(message.path);
+        } else if (message.state === 'failed') {
+            document.getElementById('transport-path').innerText = message.transport
+                ? `${TRANSPORT_POLICY_LABELS[message.transport]} 策略连接失败`
+                : '连接失败';
+        }
+    } else if (message.type === 'nat') {
+        natReport = message.report;
+        renderNatReport(natReport);
+    } else if (message.type === 'metrics') {
+        renderReport(message.report);
+        if (pendingPlayout) {
+            pendingPlayout = false;
+            fetchPlayout();
+        }
+    } else if (message.type === 'timeseries') {
+        timeSeries.push(...message.buckets);
+    } else if (message.type === 'outages') {
+        outages.push(...message.outages);
+        document.getElementById('outages').innerText = outages.length;
+        message.outages.forEach(o => {
+            console.log(`连接中断 ${o.duration.toFixed(0)} ms，丢失 ${o.packetsLost} 个包（#${o.firstSeq}-#${o.lastSeq}）`);
+        });
+    } else if (message.type === 'mediastats') {
+        renderMediaStats(message.report);
+    } else if (message.type === 'channels') {
+        channelComparison = new ChannelComparison(pc, message.profiles, {
+            frequency: parseInt(document.getElementById('frequency').value),
+            timeoutMs: PROBE_TIMEOUT_MS,
+            signaling: () => signalingWs,
+        });
+        if (dataChannel && dataChannel.readyState === 'open') {
+            channelComparison.start();
+        }
+    } else if (message.type === 'channelmetrics') {
+        renderChannelReports(message.channels);
+    } else if (message.type === 'throughput') {
+        const phaseLabels = { download: '下行测试中...', upload: '上行测试中...' };
+        if (message.phase === 'setup') {
+            throughputTest = new ThroughputTest(pc, message);
+        } else if (throughputTest) {
+            document.getElementById('throughput-status').innerText = phaseLabels[message.phase] || message.phase;
+            if (message.phase === 'upload') {
+                throughputTest.upload();
+            }
+        }
+    } else if (message.type === 'throughputresult') {
+        document.getElementById('throughput-status').innerText = '完成';
+        renderThroughputResult(message.result);
+    } else if (message.type === 'bwestats') {
+        renderBweStats(message);
+    } else if (message.type === 'impair') {
+        console.log('服务端网络损伤已生效:', message.config);
+    } else if (message.type === 'impairstats') {
+        renderImpairStats(message.report);
+    } else if (message.type === 'error') {
+        // 服务端拒绝了某项请求（如参数超出范围），主测试继续进行
+        console.warn(`服务端拒绝了 ${message.request} 请求: ${message.error}`);
+        if (message.request === 'throughput') {
+            document.getElementById('throughput-status').innerText = `未能开始：${message.error}`;
+        }
+    } else if (message.candidate) {
         try {
             await pc.addIceCandidate(new RTCIceCandidate(message));
         } catch (e) {
@@ -411,7 +778,7 @@ const handleWebSocketMessage = async (event) => {
         if (message.type === 'offer') {
             const answer = await pc.createAnswer();
             await pc.setLocalDescription(answer);
-            ws.send(JSON.stringify(pc.localDescription));
+            signalingWs.send(JSON.stringify(pc.localDescription));
         }
     }
 };
@@ -420,6 +787,9 @@ document.getElementById('start-btn').addEventListener('click', async () => {
     document.getElementById('start-btn').disabled = true;
     document.getElementById('stop-btn').style.display = 'inline-block'; // 显示停止按钮
     setStatus('建立连接中...');
+    testRunning = true;
+    resumeToken = null;
+    closeReason = null;
 
     const frequency = parseInt(document.getElementById('frequency').value);
     const size = parseInt(document.getElementById('size').value);
@@ -431,18 +801,65 @@ document.getElementById('start-btn').addEventListener('click', async () => {
     document.getElementById('sent-packets').innerText = sentPackets;
     document.getElementById('received-packets').innerText = receivedPackets;
     document.getElementById('packet-loss-rate').innerText = '0%';
+    document.getElementById('tcp-stats').style.display =
+        document.getElementById('compare-tcp').checked ? 'block' : 'none';
+    ['tcp-loss-rate', 'tcp-avg-latency', 'tcp-p90-latency', 'tcp-max-latency', 'tcp-jitter']
+        .forEach(id => document.getElementById(id).innerText = '-');
+    document.getElementById('quic-stats').style.display =
+        document.getElementById('compare-quic').checked ? 'block' : 'none';
+    ['quic-loss-rate', 'quic-avg-latency', 'quic-p90-latency', 'quic-max-latency', 'quic-jitter']
+        .forEach(id => document.getElementById(id).innerText = '-');
+    const mediaEnabled = document.getElementById('media-test').checked;
+    const audioKbps = parseInt(document.getElementById('audio-kbps').value) || 0;
+    const videoKbps = parseInt(document.getElementById('video-kbps').value) || 0;
+    document.getElementById('media-stats').style.display = mediaEnabled ? 'block' : 'none';
+    document.getElementById('media-stats-body').innerHTML = '';
+    const compareChannels = document.getElementById('compare-channels').checked;
+    document.getElementById('channel-stats').style.display = compareChannels ? 'block' : 'none';
+    document.getElementById('channel-stats-body').innerHTML = '';
+    const throughputEnabled = document.getElementById('throughput-test').checked;
+    document.getElementById('throughput-stats').style.display = throughputEnabled ? 'block' : 'none';
+    document.getElementById('throughput-status').innerText = '等待通道建立';
+    ['download', 'upload'].forEach(direction => ['goodput', 'rtt', 'loss'].forEach(field =>
+        document.getElementById(`throughput-${direction}-${field}`).innerText = '-'));
+    const bweEnabled = document.getElementById('bwe-test').checked;
+    document.getElementById('bwe-stats').style.display = bweEnabled ? 'block' : 'none';
+    document.getElementById('bwe-levels-body').innerHTML = '';
+    ['bwe-estimate', 'bwe-peak'].forEach(id => document.getElementById(id).innerText = '-');
+    natReport = null;
+    ['nat-type', 'nat-mapped', 'transport-path'].forEach(id => document.getElementById(id).innerText = '-');
+    const impairEnabled = document.getElementById('impair-test').checked;
+    document.getElementById('impair-stats').style.display = impairEnabled ? 'block' : 'none';
+    ['impair-loss-rate', 'impair-counts', 'impair-reordered'].forEach(id => document.getElementById(id).innerText = '-');
 
+    const compareIp = document.getElementById('compare-ip').checked;
+    document.getElementById('dualstack-stats').style.display = compareIp ? 'block' : 'none';
+    resetDualStackStats();
 
-    const ws = new WebSocket(document.getElementById('testNode').value);
+    const iceServers = await fetchIceServers();
+    // 双栈对比会话独立于主测试连接，与主测试同时开始
+    if (compareIp) {
+        dualStackProbes = DUAL_STACK_FAMILIES.map(family => new DualStackProbe(document.getElementById('testNode').value, family, {
+            frequency,
+            timeoutMs: PROBE_TIMEOUT_MS,
+            connectTimeoutMs: DATACHANNEL_CONNECT_TIMEOUT_MS,
+            iceServers,
+            onState: (state, detail) => renderDualStackState(family, state, detail),
+            onReport: (report) => renderDualStackReport(family, report),
+        }));
+        dualStackProbes.forEach(probe => probe.start());
+    }
+    const transport = document.getElementById('transport-policy').value;
+    const ws = new WebSocket(signalingUrl(transport));
+    signalingWs = ws;
     ws.onopen = async () => {
         console.log("WebSocket连接已打开");
         setStatus('连接已建立，准备建立数据通道测试...');
 
         const configuration = {
-            iceServers: [
-                { urls: 'stun:stun.l.google.com:19302' },
-                { urls: 'stun:stun1.l.google.com:19302' },
-            ],
+            iceServers,
+            // 仅中继策略下客户端同样只使用 TURN 候选
+            iceTransportPolicy: transport === 'relay' ? 'relay' : 'all',
             // 优化配置以降低延迟
             bundlePolicy: 'max-bundle',
             rtcpMuxPolicy: 'require'
@@ -465,10 +882,23 @@ document.getElementById('start-btn').addEventListener('click', async () => {
             console.log("数据通道已打开");
             setStatus('测试中...');
             
-            // 尝试设置二进制类型为 arraybuffer（虽然我们用字符串）
+            // 探测包为二进制，回显以 ArrayBuffer 形式接收
             dataChannel.binaryType = 'arraybuffer';
             
+            clearTimeout(connectTimeoutId);
+            if (mediaStream) {
+                applySenderBitrates(pc, audioKbps, videoKbps);
+            }
             startSendingData(frequency, size, totalPackets, duration);
+            if (channelComparison) {
+                channelComparison.start();
+            }
+            if (document.getElementById('compare-tcp').checked) {
+                startTcpProbe(frequency, renderTcpReport);
+            }
+            if (document.getElementById('compare-quic').checked) {
+                startQuicProbe(frequency, size);
+            }
         };
 
         let updateUIPending = false;
@@ -476,29 +906,22 @@ document.getElementById('start-btn').addEventListener('click', async () => {
         function updateUI() {
             if (!updateUIPending) {
                 requestAnimationFrame(() => {
-                    let totalSent, receivedCount, lossRate;
+                    let receivedCount;
                     
                     // 压测模式下，只计算当前窗口内的包
                     if (isStressMode) {
-                        totalSent = Object.keys(sentPacketTimes).length;
-                        // 重新计算接收到的包数量
                         receivedCount = 0;
                         for (let key in sentPacketTimes) {
                             if (sentPacketTimes[key].received) {
                                 receivedCount++;
                             }
                         }
-                        lossRate = totalSent > 0 ? ((totalSent - receivedCount) / totalSent) * 100 : 0;
                     } else {
-                        // 正常模式
-                        totalSent = packetCount;
                         receivedCount = receivedPackets;
-                        lossRate = totalSent > 0 ? ((totalSent - receivedCount) / totalSent) * 100 : 0;
                     }
                     
-                    // 更新显示
+                    // 更新显示（丢包率由服务端结果更新）
                     document.getElementById('received-packets').innerText = receivedCount;
-                    document.getElementById('packet-loss-rate').innerText = lossRate.toFixed(2) + '%';
                     updateUIPending = false;
                 });
             }
@@ -509,23 +932,20 @@ document.getElementById('start-btn').addEventListener('click', async () => {
             // 立即记录接收时间，最小化处理延迟
             const receiveTime = performance.now();
             
-            // 使用更快的字符串分割
-            const commaIndex = event.data.indexOf(',');
-            const packetIndex = event.data.substring(0, commaIndex);
-            const sentTime = parseFloat(event.data.substring(commaIndex + 1));
+            const view = new DataView(event.data);
+            const packetIndex = view.getUint32(0);
+            const sentTime = view.getFloat64(4);
             
             const latency = receiveTime - sentTime;
             
             // 只有在记录中存在该包时才处理
             if (sentPacketTimes[packetIndex]) {
-                // 更新实时统计（真实延迟，无滤波）
-                updateLatencyStats(latency);
-                
                 // 非压测模式下才累加 receivedPackets
                 if (!isStressMode && !sentPacketTimes[packetIndex].received) {
                     receivedPackets++;
                 }
                 sentPacketTimes[packetIndex].received = true;
+                sentPacketTimes[packetIndex].receivedTime = receiveTime;
                 sentPacketTimes[packetIndex].latency = latency;
 
                 // 异步更新UI，避免阻塞数据处理流程
@@ -533,18 +953,68 @@ document.getElementById('start-btn').addEventListener('click', async () => {
             }
         };
 
+        // ICE 重启时的候选须发往重连后的信令连接
         pc.onicecandidate = (event) => {
-            if (event.candidate) {
-                ws.send(JSON.stringify(event.candidate.toJSON()));
+            if (event.candidate && signalingWs.readyState === WebSocket.OPEN) {
+                signalingWs.send(JSON.stringify(event.candidate.toJSON()));
+            }
+        };
+
+        // 连接建立后 ICE 失败立即重启；disconnected 可能自行恢复，稍等仍未恢复再重启
+        pc.oniceconnectionstatechange = () => {
+            clearTimeout(iceRestartTimer);
+            if (!dataChannelOpen()) return;
+            if (pc.iceConnectionState === 'failed') {
+                restartIce();
+            } else if (pc.iceConnectionState === 'disconnected') {
+                iceRestartTimer = setTimeout(restartIce, ICE_RESTART_DELAY_MS);
             }
         };
 
         ws.onmessage = handleWebSocketMessage;
 
+        // 网络损伤在服务端创建回显通道时生效，须在 offer 之前发送
+        if (impairEnabled) {
+            ws.send(JSON.stringify({ type: 'impair', config: readImpairConfig() }));
+        }
+
+        // 媒体流测试须在 offer 之前通知服务端，以便服务端在 answer 中加入自己的轨道
+        if (mediaEnabled) {
+            ws.send(JSON.stringify({ type: 'media', audioKbps, videoKbps }));
+            mediaStream = createSyntheticStream();
+            if (audioKbps > 0) {
+                mediaStream.getAudioTracks().forEach(track => pc.addTrack(track, mediaStream));
+            }
+            if (videoKbps > 0) {
+                mediaStream.getVideoTracks().forEach(track => pc.addTrack(track, mediaStream));
+            }
+        }
+        // 可靠性对比通道由服务端创建，收到配置后客户端再创建相同 ID 的预协商通道
+        if (compareChannels) {
+            const lifetimeMs = parseInt(document.getElementById('packet-lifetime').value) || 0;
+            ws.send(JSON.stringify({ type: 'channels', lifetimeMs }));
+        }
+        if (throughputEnabled) {
+            const seconds = parseInt(document.getElementById('throughput-seconds').value) || 0;
+            ws.send(JSON.stringify({ type: 'throughput', seconds }));
+        }
+        // 带宽估计：服务端按 GCC 目标码率发送视频，客户端只接收并回传 TWCC 反馈
+        if (bweEnabled) {
+            const maxKbps = parseInt(document.getElementById('bwe-max-kbps').value) || 0;
+            ws.send(JSON.stringify({ type: 'bwe', maxKbps }));
+            pc.addTransceiver('video', { direction: 'recvonly' });
+        }
+
         const offer = await pc.createOffer();
         await pc.setLocalDescription(offer);
         ws.send(JSON.stringify(pc.localDescription));
 
+        connectTimeoutId = setTimeout(() => {
+            if (dataChannel.readyState !== 'open') {
+                fallbackToWebSocket(frequency, duration);
+            }
+        }, DATACHANNEL_CONNECT_TIMEOUT_MS);
+
         // 初始化图表
         const ctx = document.getElementById('chart').getContext('2d');
         chart = new Chart(ctx, {
@@ -598,14 +1068,13 @@ document.getElementById('start-btn').addEventListener('click', async () => {
     };
 
     ws.onerror = () => {
+        // 会话建立后的错误随后触发 close，由断线重连处理
+        if (resumeToken) return;
         setStatus('连接失败');
         document.getElementById('start-btn').disabled = false;
     };
 
-    ws.onclose = () => {
-        stopTest();
-        setStatus('连接关闭');
-    };
+    ws.onclose = handleSignalingClose;
 });
 
 // 停止按钮事件监听器
@@ -624,7 +1093,21 @@ const updateChart = () => {
 
     let dataToProcess;
     
-    if (isStressMode) {
+    if (isStressMode && timeSeries.length > 0) {
+        // 压测模式：使用服务端聚合的时间序列展示整个测试过程
+        const groupSize = Math.max(1, Math.ceil(timeSeries.length / 120)); // 固定分成120组
+        for (let i = 0; i < timeSeries.length; i += groupSize) {
+            const group = timeSeries.slice(i, i + groupSize).filter(b => b.rtt.count > 0);
+            if (group.length === 0) continue;
+
+            const count = group.reduce((acc, b) => acc + b.rtt.count, 0);
+            const sum = group.reduce((acc, b) => acc + b.rtt.mean * b.rtt.count, 0);
+            labels.push(group[0].offset.toFixed(0) + 's');
+            dataAvg.push(sum / count);
+            dataMax.push(Math.max(...group.map(b => b.rtt.max)));
+            dataMin.push(Math.min(...group.map(b => b.rtt.min)));
+        }
+    } else if (isStressMode) {
         // 压测模式：只处理最近10秒的数据（滚动窗口）
         const currentTime = performance.now();
         const cutoffTime = currentTime - 10000; // 10秒前
@@ -0,0 +1,105 @@
+// DataChannel 可靠性对比：在服务端预协商的多个通道上并行发送探测包，比较送达率和延迟
+
+class ChannelComparison {
+    // profiles 为服务端回传的通道配置；options: { frequency, timeoutMs, signaling }，
+    // signaling 返回当前的信令连接（断线重连后会更换）
+    constructor(pc, profiles, options) {
+        this.options = options;
+        this.channels = profiles.map(profile => {
+            const init = { negotiated: true, id: profile.id, ordered: profile.ordered };
+            if (profile.maxRetransmits !== undefined) init.maxRetransmits = profile.maxRetransmits;
+            if (profile.maxPacketLifeTime !== undefined) init.maxPacketLifeTime = profile.maxPacketLifeTime;
+            const channel = pc.createDataChannel(profile.name, init);
+            const state = { name: profile.name, channel, pending: {}, seq: 0, cursor: 0 };
+            channel.onmessage = (event) => this.handleMessage(state, event.data);
+            return state;
+        });
+    }
+
+    start() {
+        const intervalMs = 1000 / this.options.frequency;
+        this.sendTimer = setInterval(() => this.sendProbes(), intervalMs);
+        this.flushTimer = setInterval(() => this.flush(false), 500);
+    }
+
+    stop() {
+        clearInterval(this.sendTimer);
+        clearInterval(this.flushTimer);
+        this.flush(true);
+    }
+
+    // 同一时刻在每个通道各发一个探测包
+    sendProbes() {
+        this.channels.forEach(state => {
+            if (state.channel.readyState !== 'open') return;
+            const timestamp = performance.now();
+            state.pending[state.seq] = { sentTime: timestamp, received: false };
+            state.channel.send(`${state.seq},${timestamp}`);
+            state.seq++;
+        });
+    }
+
+    handleMessage(state, data) {
+        const receiveTime = performance.now();
+        const seq = data.substring(0, data.indexOf(','));
+        const entry = state.pending[seq];
+        if (entry && !entry.received) {
+            entry.received = true;
+            entry.receivedTime = receiveTime;
+        }
+    }
+
+    // 与主测试相同的上报格式，带上通道名称
+    flush(final) {
+        const signaling = this.options.signaling();
+        if (signaling.readyState !== WebSocket.OPEN) return;
+        const cutoff = performance.now() - this.options.timeoutMs;
+        this.channels.forEach(state => {
+            const samples = [];
+            while (state.cursor < state.seq) {
+                const entry = state.pending[state.cursor];
+                if (!entry.received && !final && entry.sentTime > cutoff) {
+                    break;
+                }
+                samples.push(entry.received
+                    ? { seq: state.cursor, sent: entry.sentTime, received: entry.receivedTime }
+                    : { seq: state.cursor, sent: entry.sentTime, lost: true });
+                delete state.pending[state.cursor];
+                state.cursor++;
+            }
+            if (samples.length > 0) {
+                signaling.send(JSON.stringify({ type: 'samples', channel: state.name, samples }));
+            }
+        });
+    }
+}
+
+const CHANNEL_LABELS = {
+    unreliable: '不重传',
+    lifetime: '限时重传',
+    reliable: '可靠有序',
+};
+
+const signedMs = (value) => `${value >= 0 ? '+' : ''}${value.toFixed(1)}`;
+
+const renderChannelReports = (reports) => {
+    const body = document.getElementById('channel-stats-body');
+    body.innerHTML = '';
+    reports.forEach(r => {
+        const row = document.createElement('tr');
+        const latency = r.report.latency;
+        [
+            CHANNEL_LABELS[r.channel] || r.channel,
+            `${(r.deliveredRatio * 100).toFixed(2)}%`,
+            latency.count > 0 ? latency.p50.toFixed(1) : '-',
+            latency.count > 0 ? latency.p99.toFixed(1) : '-',
+            signedMs(r.latencyInflation.p50),
+            signedMs(r.latencyInflation.p99),
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.innerText = value;
+            row.appendChild(cell);
+        });
+        body.appendChild(row);
+    });
+};
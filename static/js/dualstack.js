@@ -0,0 +1,201 @@
+// 双栈对比：分别以只含 IPv4 / 只含 IPv6 候选的会话并行运行丢包测试，
+// 暴露 ICE 自动选路时被掩盖的单一地址族故障（常见于 IPv6 路径不通）
+
+const DUAL_STACK_FAMILIES = ['ipv4', 'ipv6'];
+
+// 信令地址附加 network 参数，服务端据此限定本会话的候选地址族
+const familySignalingUrl = (nodeUrl, family) => {
+    const url = new URL(nodeUrl, window.location.href);
+    if (url.protocol === 'https:') {
+        url.protocol = 'wss:';
+    } else if (url.protocol === 'http:') {
+        url.protocol = 'ws:';
+    }
+    url.searchParams.set('network', family);
+    return url.toString();
+};
+
+class DualStackProbe {
+    // options: { frequency, timeoutMs, connectTimeoutMs, iceServers, onState(state, detail), onReport(report) }
+    constructor(nodeUrl, family, options) {
+        this.url = familySignalingUrl(nodeUrl, family);
+        this.family = family;
+        this.options = options;
+        this.pending = {};
+        this.seq = 0;
+        this.cursor = 0;
+        this.outstanding = 0; // 已上报但尚未收到结果的批次
+        this.stopping = false;
+    }
+
+    start() {
+        this.options.onState('connecting');
+        this.socket = new WebSocket(this.url);
+        this.socket.onopen = () => this.negotiate();
+        this.socket.onmessage = (event) => this.handleMessage(JSON.parse(event.data));
+        this.socket.onclose = () => this.close();
+        this.socket.onerror = () => this.options.onState('failed', '信令连接失败');
+    }
+
+    async negotiate() {
+        this.pc = new RTCPeerConnection({ iceServers: this.options.iceServers });
+        this.channel = this.pc.createDataChannel('dataChannel', { ordered: false, maxRetransmits: 0 });
+        this.channel.binaryType = 'arraybuffer';
+        this.channel.onopen = () => this.onOpen();
+        this.channel.onmessage = (event) => this.handleEcho(event.data);
+        this.pc.onicecandidate = (event) => {
+            if (event.candidate && this.socket.readyState === WebSocket.OPEN) {
+                this.socket.send(JSON.stringify(event.candidate.toJSON()));
+            }
+        };
+        const offer = await this.pc.createOffer();
+        await this.pc.setLocalDescription(offer);
+        this.socket.send(JSON.stringify(this.pc.localDescription));
+
+        // 服务端只有该地址族的候选，客户端或路径不支持时 ICE 无法完成
+        this.connectTimer = setTimeout(() => {
+            if (this.channel.readyState !== 'open') {
+                this.options.onState('failed', `${this.options.connectTimeoutMs / 1000} 秒内未能建立连接`);
+                this.close();
+            }
+        }, this.options.connectTimeoutMs);
+    }
+
+    async onOpen() {
+        clearTimeout(this.connectTimer);
+        this.options.onState('testing', await this.selectedPath());
+        const intervalMs = 1000 / this.options.frequency;
+        this.sendTimer = setInterval(() => this.sendProbe(), intervalMs);
+        this.flushTimer = setInterval(() => this.flush(false), 500);
+    }
+
+    // 当前选中的候选对，显示实际使用的服务端地址和传输协议
+    async selectedPath() {
+        const stats = await this.pc.getStats();
+        let path = '';
+        stats.forEach(report => {
+            if (report.type !== 'transport' || !report.selectedCandidatePairId) return;
+            const pair = stats.get(report.selectedCandidatePairId);
+            const remote = pair && stats.get(pair.remoteCandidateId);
+            if (remote) {
+                path = `${remote.address}:${remote.port} (${remote.protocol})`;
+            }
+        });
+        return path;
+    }
+
+    // 停止发送，上报剩余样本后关闭（等待服务端回传最终结果）
+    stop() {
+        clearTimeout(this.connectTimer);
+        clearInterval(this.sendTimer);
+        clearInterval(this.flushTimer);
+        if (!this.socket || this.socket.readyState !== WebSocket.OPEN || this.seq === 0) {
+            this.close();
+            return;
+        }
+        this.stopping = true;
+        this.flush(true);
+        this.closeTimer = setTimeout(() => this.close(), 2000);
+    }
+
+    close() {
+        clearTimeout(this.connectTimer);
+        clearTimeout(this.closeTimer);
+        clearInterval(this.sendTimer);
+        clearInterval(this.flushTimer);
+        if (this.pc) {
+            this.pc.close();
+        }
+        if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+            this.socket.close();
+        }
+    }
+
+    sendProbe() {
+        if (this.channel.readyState !== 'open') return;
+        const buffer = new ArrayBuffer(PROBE_FRAME_BYTES);
+        const view = new DataView(buffer);
+        const timestamp = performance.now();
+        view.setUint32(0, this.seq);
+        view.setFloat64(4, timestamp);
+        this.pending[this.seq] = { sentTime: timestamp, received: false };
+        this.channel.send(buffer);
+        this.seq++;
+    }
+
+    handleEcho(data) {
+        const receiveTime = performance.now();
+        const entry = this.pending[new DataView(data).getUint32(0)];
+        if (entry && !entry.received) {
+            entry.received = true;
+            entry.receivedTime = receiveTime;
+        }
+    }
+
+    async handleMessage(message) {
+        if (message.type === 'metrics') {
+            this.outstanding--;
+            this.options.onReport(message.report);
+            if (this.stopping && this.outstanding <= 0) {
+                this.close();
+            }
+        } else if (message.candidate) {
+            try {
+                await this.pc.addIceCandidate(new RTCIceCandidate(message));
+            } catch (e) {
+                console.error(`${this.family} 会话添加 ICE 候选时出错`, e);
+            }
+        } else if (message.sdp) {
+            await this.pc.setRemoteDescription(new RTCSessionDescription(message));
+        }
+    }
+
+    // 与主测试相同的上报格式，由服务端统一计算指标
+    flush(final) {
+        if (this.socket.readyState !== WebSocket.OPEN) return;
+        const cutoff = performance.now() - this.options.timeoutMs;
+        const samples = [];
+        while (this.cursor < this.seq) {
+            const entry = this.pending[this.cursor];
+            if (!entry.received && !final && entry.sentTime > cutoff) {
+                break;
+            }
+            samples.push(entry.received
+                ? { seq: this.cursor, sent: entry.sentTime, received: entry.receivedTime }
+                : { seq: this.cursor, sent: entry.sentTime, lost: true });
+            delete this.pending[this.cursor];
+            this.cursor++;
+        }
+        if (samples.length > 0 || final) {
+            this.outstanding++;
+            this.socket.send(JSON.stringify({ type: 'samples', samples, final }));
+        }
+    }
+}
+
+const DUAL_STACK_STATE_LABELS = {
+    connecting: '连接中',
+    testing: '测试中',
+    failed: '无法连接',
+};
+
+// 并排显示两个地址族的结果
+const renderDualStackState = (family, state, detail) => {
+    const label = DUAL_STACK_STATE_LABELS[state] || state;
+    document.getElementById(`${family}-state`).innerText = detail ? `${label}（${detail}）` : label;
+};
+
+const renderDualStackReport = (family, report) => {
+    document.getElementById(`${family}-loss-rate`).innerText = (report.lossRatio * 100).toFixed(2) + '%';
+    if (report.latency.count > 0) {
+        document.getElementById(`${family}-avg-latency`).innerText = report.latency.mean.toFixed(3);
+        document.getElementById(`${family}-p90-latency`).innerText = report.latency.p90.toFixed(3);
+        document.getElementById(`${family}-jitter`).innerText = report.jitter.toFixed(3);
+    }
+};
+
+const resetDualStackStats = () => {
+    DUAL_STACK_FAMILIES.forEach(family =>
+        ['state', 'loss-rate', 'avg-latency', 'p90-latency', 'jitter']
+            .forEach(field => document.getElementById(`${family}-${field}`).innerText = '-'));
+};
@@ -0,0 +1,32 @@
+// 服务端网络损伤模拟：按页面参数生成 impair 配置，并显示服务端实际注入的损伤作为对照
+
+// 平均突发长度大于 1 时使用 Gilbert 模型：坏状态全丢，r = 1/突发长度，
+// p = 丢包率 × r / (1 - 丢包率)，使稳态丢包率等于设定值
+const readImpairConfig = () => {
+    const value = (id) => parseFloat(document.getElementById(id).value) || 0;
+    const lossRatio = Math.min(value('impair-loss') / 100, 0.99);
+    const burstLength = value('impair-burst');
+    const config = {
+        delayMs: value('impair-delay'),
+        jitterMs: value('impair-jitter'),
+        reorderRatio: Math.min(value('impair-reorder') / 100, 1),
+        rateKbps: Math.round(value('impair-rate')),
+    };
+    if (lossRatio > 0 && burstLength > 1) {
+        const r = 1 / burstLength;
+        config.burst = { p: Math.min(lossRatio * r / (1 - lossRatio), 1), r };
+    } else {
+        config.lossRatio = lossRatio;
+    }
+    return config;
+};
+
+// 服务端配置了全局损伤时，即使页面未勾选也会收到对照数据
+const renderImpairStats = (report) => {
+    const stats = report.stats;
+    document.getElementById('impair-stats').style.display = 'block';
+    document.getElementById('impair-loss-rate').innerText = (stats.lossRatio * 100).toFixed(2) + '%';
+    document.getElementById('impair-counts').innerText =
+        `${stats.dropped + stats.queueDropped} / ${stats.packets}`;
+    document.getElementById('impair-reordered').innerText = stats.reordered;
+};
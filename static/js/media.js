@@ -0,0 +1,97 @@
+// RTP 媒体流测试和带宽估计测试：生成合成音视频轨道，并显示服务端汇总的统计
+
+// 合成媒体源：振荡器音频 + 持续变化的画布视频（保证编码器持续产生数据）
+const createSyntheticStream = () => {
+    const audioContext = new AudioContext();
+    const oscillator = audioContext.createOscillator();
+    const destination = audioContext.createMediaStreamDestination();
+    oscillator.connect(destination);
+    oscillator.start();
+
+    const canvas = document.createElement('canvas');
+    canvas.width = 640;
+    canvas.height = 360;
+    const ctx = canvas.getContext('2d');
+    const drawTimer = setInterval(() => {
+        const image = ctx.createImageData(canvas.width, canvas.height);
+        for (let i = 0; i < image.data.length; i += 4) {
+            const v = Math.random() * 255;
+            image.data[i] = image.data[i + 1] = image.data[i + 2] = v;
+            image.data[i + 3] = 255;
+        }
+        ctx.putImageData(image, 0, 0);
+    }, 1000 / 30);
+
+    const stream = new MediaStream([
+        ...destination.stream.getAudioTracks(),
+        ...canvas.captureStream(30).getVideoTracks(),
+    ]);
+    stream.stopSynthetic = () => {
+        clearInterval(drawTimer);
+        stream.getTracks().forEach(track => track.stop());
+        audioContext.close();
+    };
+    return stream;
+};
+
+// 限制发送码率，与服务端合成流保持一致
+const applySenderBitrates = async (pc, audioKbps, videoKbps) => {
+    for (const sender of pc.getSenders()) {
+        if (!sender.track) continue;
+        const kbps = sender.track.kind === 'audio' ? audioKbps : videoKbps;
+        const params = sender.getParameters();
+        if (!params.encodings || params.encodings.length === 0) continue;
+        params.encodings[0].maxBitrate = kbps * 1000;
+        try {
+            await sender.setParameters(params);
+        } catch (error) {
+            console.warn('设置发送码率失败:', error);
+        }
+    }
+};
+
+const renderMediaStats = (report) => {
+    const body = document.getElementById('media-stats-body');
+    body.innerHTML = '';
+    report.streams.forEach(stream => {
+        const row = document.createElement('tr');
+        const direction = stream.direction === 'send' ? '下行' : '上行';
+        const lossRate = stream.packets > 0 ? (stream.packetsLost / (stream.packets + Math.max(stream.packetsLost, 0))) * 100 : 0;
+        [
+            `${stream.kind === 'audio' ? '音频' : '视频'} ${direction}`,
+            stream.packets,
+            stream.packetsLost,
+            `${lossRate.toFixed(2)}%`,
+            `${(stream.fractionLost * 100).toFixed(2)}%`,
+            stream.jitter.toFixed(2),
+            stream.roundTripTime ? stream.roundTripTime.toFixed(1) : '-',
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.innerText = value;
+            row.appendChild(cell);
+        });
+        body.appendChild(row);
+    });
+};
+
+const renderBweStats = (message) => {
+    document.getElementById('bwe-estimate').innerText = message.point.estimateKbps.toFixed(0);
+    document.getElementById('bwe-peak').innerText = message.report.peakKbps.toFixed(0);
+    const body = document.getElementById('bwe-levels-body');
+    body.innerHTML = '';
+    message.report.levels.forEach(level => {
+        const row = document.createElement('tr');
+        [
+            `≥ ${level.kbps}`,
+            level.points,
+            `${(level.lossRatio * 100).toFixed(2)}%`,
+            level.roundTripTime.toFixed(1),
+            level.delayGradient.toFixed(2),
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.innerText = value;
+            row.appendChild(cell);
+        });
+        body.appendChild(row);
+    });
+};
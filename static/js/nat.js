@@ -0,0 +1,58 @@
+// NAT 行为检测：向节点内置 STUN 服务器的主地址、备用端口和备用地址分别请求映射，
+// 将得到的 srflx 候选交给服务端判断映射行为（浏览器无法测试过滤行为）
+
+const NAT_GATHER_TIMEOUT_MS = 5000;
+const NAT_TYPE_LABELS = {
+    'open': '无 NAT（公网地址）',
+    'full-cone': '完全锥形',
+    'restricted-cone': '地址限制锥形',
+    'port-restricted-cone': '端口限制锥形',
+    'cone': '锥形（端点无关映射）',
+    'symmetric': '对称型',
+    'unknown': '未知',
+};
+
+// 收集 srflx 候选，stun 为服务端 session 消息中的 STUN 地址
+const discoverNat = (stun) => new Promise((resolve) => {
+    const servers = [stun.primary, stun.alternatePort, stun.alternateAddress].filter(Boolean);
+    const natPc = new RTCPeerConnection({ iceServers: servers.map(urls => ({ urls })) });
+    const candidates = [];
+    let finished = false;
+    const finish = () => {
+        if (finished) return;
+        finished = true;
+        clearTimeout(timer);
+        natPc.close();
+        resolve(candidates);
+    };
+    const timer = setTimeout(finish, NAT_GATHER_TIMEOUT_MS);
+    natPc.onicecandidate = (event) => {
+        if (!event.candidate) {
+            finish();
+            return;
+        }
+        const c = event.candidate;
+        if (c.type === 'srflx' && c.protocol === 'udp') {
+            candidates.push({
+                // event.url 为产生该候选的 STUN 服务器（部分浏览器不提供）
+                url: event.url || c.url || '',
+                address: c.address,
+                port: c.port,
+                relatedAddress: c.relatedAddress || '',
+                relatedPort: c.relatedPort || 0,
+            });
+        }
+    };
+    natPc.createDataChannel('nat');
+    natPc.createOffer().then(offer => natPc.setLocalDescription(offer)).catch(finish);
+});
+
+const renderNatReport = (report) => {
+    let text = NAT_TYPE_LABELS[report.type] || report.type;
+    if (report.needsTurn) {
+        text += '（对方同样位于 NAT 后时需要 TURN 中继）';
+    }
+    document.getElementById('nat-type').innerText = text;
+    document.getElementById('nat-mapped').innerText =
+        report.mappedAddresses && report.mappedAddresses.length > 0 ? report.mappedAddresses.join(', ') : '-';
+};
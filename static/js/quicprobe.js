@@ -0,0 +1,177 @@
+// QUIC (WebTransport) 探测通道：探测帧以不重传的 QUIC DATAGRAM 回显，无需 WebRTC 协商，
+// 需要节点启用 HTTPS 和 HTTP/3
+
+// 由测试节点地址推导 WebTransport 地址（与信令同源的 /webtransport）
+const webTransportUrlFor = (nodeUrl) => {
+    const url = new URL(nodeUrl, window.location.href);
+    if (url.protocol === 'wss:' || url.protocol === 'http:' || url.protocol === 'ws:') {
+        url.protocol = 'https:';
+    }
+    url.pathname = '/webtransport';
+    url.search = '';
+    return url.toString();
+};
+
+class QUICProbe {
+    // options: { frequency, size, timeoutMs, onSession(id), onReport(report), onError(err) }
+    constructor(nodeUrl, options) {
+        this.url = webTransportUrlFor(nodeUrl);
+        this.options = options;
+        this.pending = {};
+        this.seq = 0;
+        this.cursor = 0;
+        this.sessionId = null;
+        this.outstanding = 0; // 已上报但尚未收到结果的批次
+        this.stopping = false;
+        this.closed = false;
+    }
+
+    static supported() {
+        return typeof WebTransport !== 'undefined';
+    }
+
+    async start() {
+        try {
+            this.transport = new WebTransport(this.url);
+            await this.transport.ready;
+            this.transport.closed.catch(() => {}).finally(() => this.handleClose());
+            // 服务端打开控制流并在其上发送会话ID
+            const streams = this.transport.incomingBidirectionalStreams.getReader();
+            const { value: stream } = await streams.read();
+            streams.releaseLock();
+            this.controlReadable = stream.readable;
+            this.control = stream.writable.getWriter();
+            this.datagrams = this.transport.datagrams.writable.getWriter();
+            // 探测帧不超过单个数据报，超出的部分无法发送
+            this.frameSize = Math.max(Math.min(this.options.size || 12, this.transport.datagrams.maxDatagramSize), 12);
+        } catch (err) {
+            this.handleClose();
+            if (this.options.onError) this.options.onError(err);
+            return;
+        }
+        this.readControl();
+        this.readDatagrams();
+        const intervalMs = 1000 / this.options.frequency;
+        this.sendTimer = setInterval(() => this.sendProbe(), intervalMs);
+        this.flushTimer = setInterval(() => this.flush(false), 500);
+    }
+
+    // 停止发送，上报剩余样本后关闭（等待服务端回传最终结果）
+    stop() {
+        this.clearTimers();
+        if (!this.control || this.closed) {
+            if (this.transport) this.close();
+            return;
+        }
+        this.stopping = true;
+        this.flush(true);
+        this.closeTimer = setTimeout(() => this.close(), 2000);
+    }
+
+    close() {
+        if (!this.closed) {
+            this.transport.close();
+        }
+    }
+
+    handleClose() {
+        this.closed = true;
+        this.clearTimers();
+    }
+
+    clearTimers() {
+        clearInterval(this.sendTimer);
+        clearInterval(this.flushTimer);
+    }
+
+    sendProbe() {
+        if (this.closed) return;
+        const timestamp = performance.now();
+        const frame = new ArrayBuffer(this.frameSize);
+        const view = new DataView(frame);
+        view.setUint32(0, this.seq);
+        view.setFloat64(4, timestamp);
+        this.pending[this.seq] = { sentTime: timestamp, received: false };
+        this.datagrams.write(new Uint8Array(frame)).catch(() => {});
+        this.seq++;
+    }
+
+    // 控制消息为换行分隔的 JSON，格式与信令通道相同
+    async readControl() {
+        const reader = this.controlReadable.pipeThrough(new TextDecoderStream()).getReader();
+        let buffered = '';
+        try {
+            for (;;) {
+                const { value, done } = await reader.read();
+                if (done) break;
+                buffered += value;
+                let newline;
+                while ((newline = buffered.indexOf('\n')) >= 0) {
+                    this.handleMessage(JSON.parse(buffered.slice(0, newline)));
+                    buffered = buffered.slice(newline + 1);
+                }
+            }
+        } catch (err) {
+            // 连接关闭时读取失败
+        }
+        this.close();
+    }
+
+    handleMessage(message) {
+        if (message.type === 'session') {
+            this.sessionId = message.id;
+            if (this.options.onSession) this.options.onSession(message.id);
+        } else if (message.type === 'metrics') {
+            this.outstanding--;
+            if (this.options.onReport) this.options.onReport(message.report);
+            if (this.stopping && this.outstanding <= 0) {
+                clearTimeout(this.closeTimer);
+                this.close();
+            }
+        }
+    }
+
+    // 回显的数据报与发出时相同，服务端不附加时间戳
+    async readDatagrams() {
+        const reader = this.transport.datagrams.readable.getReader();
+        try {
+            for (;;) {
+                const { value, done } = await reader.read();
+                if (done) break;
+                const receiveTime = performance.now();
+                if (value.byteLength < 12) continue;
+                const view = new DataView(value.buffer, value.byteOffset, value.byteLength);
+                const entry = this.pending[view.getUint32(0)];
+                if (entry && !entry.received) {
+                    entry.received = true;
+                    entry.receivedTime = receiveTime;
+                }
+            }
+        } catch (err) {
+            // 连接关闭时读取失败
+        }
+    }
+
+    // 与 DataChannel 路径相同的上报格式，由服务端统一计算指标
+    flush(final) {
+        if (this.closed) return;
+        const cutoff = performance.now() - this.options.timeoutMs;
+        const samples = [];
+        while (this.cursor < this.seq) {
+            const entry = this.pending[this.cursor];
+            if (!entry.received && !final && entry.sentTime > cutoff) {
+                break;
+            }
+            samples.push(entry.received
+                ? { seq: this.cursor, sent: entry.sentTime, received: entry.receivedTime }
+                : { seq: this.cursor, sent: entry.sentTime, lost: true });
+            delete this.pending[this.cursor];
+            this.cursor++;
+        }
+        if (samples.length > 0 || final) {
+            this.outstanding++;
+            const line = JSON.stringify({ type: 'samples', samples, final }) + '\n';
+            this.control.write(new TextEncoder().encode(line)).catch(() => {});
+        }
+    }
+}
@@ -2,6 +2,7 @@ const statusEl = document.getElementById("status");
 const downloadEl = document.getElementById("download-speed");
 const uploadEl = document.getElementById("upload-speed");
 const latencyEl = document.getElementById("latency");
+const latencyProtocolEl = document.getElementById("latency-protocol");
 const startBtn = document.getElementById("start-test");
 const caseResultsBody = document.getElementById("case-results-body");
 const progressFill = document.getElementById("progress-fill");
@@ -52,6 +53,21 @@ const TEST_CASES = [
 
 const SUMMARY_CASE = TEST_CASES[TEST_CASES.length - 1];
 const caseRows = new Map();
+
+// 服务端在响应头中返回本次请求使用的 HTTP 版本（http/1.1、h2、h3），
+// 节点启用 HTTP/3 后浏览器收到 Alt-Svc 才会改用 QUIC，因此同一轮测速中协议可能变化
+const PROTOCOL_HEADER = "X-Protocol";
+const caseProtocols = new Map();
+
+const recordProtocol = (protocols, response) => {
+    const protocol = response.headers.get(PROTOCOL_HEADER);
+    if (protocol) {
+        protocols.add(protocol);
+    }
+};
+
+const formatProtocols = (protocols) => (protocols.size > 0 ? [...protocols].join(" / ") : "-");
+
 const describeStage = (direction, label, totalBytes) => {
     if (!totalBytes || totalBytes <= 0) {
         return `${direction} ${label}`;
@@ -179,9 +195,12 @@ const initializeCaseTable = () => {
         const uploadCell = document.createElement("td");
         uploadCell.textContent = "-";
 
-        row.append(sizeCell, downloadCell, uploadCell);
+        const protocolCell = document.createElement("td");
+        protocolCell.textContent = "-";
+
+        row.append(sizeCell, downloadCell, uploadCell, protocolCell);
         caseResultsBody.appendChild(row);
-        caseRows.set(testCase.key, { downloadCell, uploadCell });
+        caseRows.set(testCase.key, { downloadCell, uploadCell, protocolCell });
     });
 };
 
@@ -194,14 +213,32 @@ const setCaseResult = (key, type, value) => {
     targetCell.textContent = formatNumber(value);
 };
 
+const setCaseProtocols = (key, protocols) => {
+    const row = caseRows.get(key);
+    if (!row) {
+        return;
+    }
+    if (!caseProtocols.has(key)) {
+        caseProtocols.set(key, new Set());
+    }
+    const merged = caseProtocols.get(key);
+    protocols.forEach((protocol) => merged.add(protocol));
+    row.protocolCell.textContent = formatProtocols(merged);
+};
+
 const resetCaseResults = () => {
+    caseProtocols.clear();
     TEST_CASES.forEach(({ key }) => {
         setCaseResult(key, "download", NaN);
         setCaseResult(key, "upload", NaN);
+        const row = caseRows.get(key);
+        if (row) {
+            row.protocolCell.textContent = "-";
+        }
     });
 };
 
-const measureLatency = async () => {
+const measureLatency = async (protocols) => {
     const attempts = 3;
     let total = 0;
     for (let i = 0; i < attempts; i++) {
@@ -212,11 +249,12 @@ const measureLatency = async () => {
         }
         await response.json();
         total += performance.now() - start;
+        recordProtocol(protocols, response);
     }
     return total / attempts;
 };
 
-const runDownloadTest = async (packetBytes, totalBytes) => {
+const runDownloadTest = async (packetBytes, totalBytes, protocols) => {
     const packetSize = Math.max(1, Math.floor(packetBytes));
     // 保证每个场景传输的总数据量为 totalBytes（由调用者传入），避免小包场景只测一次导致突发性能。
     const targetBytes = Math.max(1, Math.floor(totalBytes));
@@ -232,6 +270,7 @@ const runDownloadTest = async (packetBytes, totalBytes) => {
         if (!response.ok) {
             throw new Error("下载测试请求失败");
         }
+        recordProtocol(protocols, response);
 
         if (response.body && response.body.getReader) {
             const reader = response.body.getReader();
@@ -273,7 +312,7 @@ const fillRandomBytes = (target) => {
     }
 };
 
-const runUploadTest = async (packetBytes, totalBytes) => {
+const runUploadTest = async (packetBytes, totalBytes, protocols) => {
     const packetSize = Math.max(1, Math.floor(packetBytes));
     // 保证每个场景传输的总数据量为 totalBytes（由调用者传入），避免小包场景只测一次导致突发性能。
     const targetBytes = Math.max(1, Math.floor(totalBytes));
@@ -296,6 +335,7 @@ const runUploadTest = async (packetBytes, totalBytes) => {
         if (!response.ok) {
             throw new Error("上传测试请求失败");
         }
+        recordProtocol(protocols, response);
         const result = await response.json();
         uploadedTotal += typeof result.receivedBytes === "number" ? result.receivedBytes : chunkBytes;
 
@@ -325,6 +365,7 @@ startBtn.addEventListener("click", async () => {
     updateMetric(downloadEl, NaN);
     updateMetric(uploadEl, NaN);
     updateMetric(latencyEl, NaN);
+    latencyProtocolEl.textContent = "ms";
 
     if (totalStages > 0) {
         announceStageStart();
@@ -332,8 +373,10 @@ startBtn.addEventListener("click", async () => {
 
     try {
         setStatus("测量延迟...");
-        const latency = await measureLatency();
+        const latencyProtocols = new Set();
+        const latency = await measureLatency(latencyProtocols);
         updateMetric(latencyEl, latency);
+        latencyProtocolEl.textContent = `ms · ${formatProtocols(latencyProtocols)}`;
         markStageComplete();
 
         const downloadResults = new Map();
@@ -342,9 +385,11 @@ startBtn.addEventListener("click", async () => {
             const label = testCase.displayLabel ?? testCase.label;
             setStatus(`测试下载速度（${label}）...`);
             const { packetBytes, totalBytes } = testCase.download;
-            const downloadMbps = await runDownloadTest(packetBytes, totalBytes);
+            const protocols = new Set();
+            const downloadMbps = await runDownloadTest(packetBytes, totalBytes, protocols);
             downloadResults.set(testCase.key, downloadMbps);
             setCaseResult(testCase.key, "download", downloadMbps);
+            setCaseProtocols(testCase.key, protocols);
             markStageComplete();
         }
 
@@ -354,9 +399,11 @@ startBtn.addEventListener("click", async () => {
             const label = testCase.displayLabel ?? testCase.label;
             setStatus(`测试上传速度（${label}）...`);
             const { packetBytes, totalBytes } = testCase.upload;
-            const uploadMbps = await runUploadTest(packetBytes, totalBytes);
+            const protocols = new Set();
+            const uploadMbps = await runUploadTest(packetBytes, totalBytes, protocols);
             uploadResults.set(testCase.key, uploadMbps);
             setCaseResult(testCase.key, "upload", uploadMbps);
+            setCaseProtocols(testCase.key, protocols);
             markStageComplete();
         }
 
@@ -0,0 +1,52 @@
+// DataChannel (SCTP) 吞吐量测试：服务端先向客户端灌包（下行），再由客户端灌包（上行），
+// 期间服务端在不重传通道上发送探测包测量负载下的 RTT 和丢包
+
+const THROUGHPUT_CHUNK_SIZE = 16 * 1024;
+const THROUGHPUT_HIGH_WATER = 1024 * 1024;
+const THROUGHPUT_LOW_WATER = 256 * 1024;
+
+class ThroughputTest {
+    // setup 为服务端回传的 { bulkId, pingId, seconds }
+    constructor(pc, setup) {
+        this.seconds = setup.seconds;
+        this.bulk = pc.createDataChannel('throughput', { negotiated: true, id: setup.bulkId });
+        this.bulk.binaryType = 'arraybuffer';
+        this.bulk.bufferedAmountLowThreshold = THROUGHPUT_LOW_WATER;
+        this.ping = pc.createDataChannel('throughput-ping', {
+            negotiated: true, id: setup.pingId, ordered: false, maxRetransmits: 0,
+        });
+        // 探测包原样回显，由服务端计算 RTT
+        this.ping.onmessage = (event) => {
+            if (this.ping.readyState === 'open') this.ping.send(event.data);
+        };
+    }
+
+    // 上行阶段：按缓冲水位持续发送，直到持续时间结束
+    upload() {
+        const chunk = new Uint8Array(THROUGHPUT_CHUNK_SIZE);
+        crypto.getRandomValues(chunk);
+        const deadline = performance.now() + this.seconds * 1000;
+        const pump = () => {
+            while (this.bulk.readyState === 'open' && this.bulk.bufferedAmount < THROUGHPUT_HIGH_WATER) {
+                if (performance.now() >= deadline) {
+                    this.bulk.onbufferedamountlow = null;
+                    return;
+                }
+                this.bulk.send(chunk);
+            }
+        };
+        this.bulk.onbufferedamountlow = pump;
+        pump();
+    }
+}
+
+const renderThroughputResult = (result) => {
+    [result.download, result.upload].forEach(phase => {
+        const prefix = `throughput-${phase.direction}`;
+        const rtt = phase.pings.latency;
+        document.getElementById(`${prefix}-goodput`).innerText = phase.goodputMbps.toFixed(2);
+        document.getElementById(`${prefix}-rtt`).innerText =
+            rtt.count > 0 ? `${rtt.p50.toFixed(1)} / ${rtt.p99.toFixed(1)}` : '-';
+        document.getElementById(`${prefix}-loss`).innerText = `${(phase.pings.lossRatio * 100).toFixed(2)}%`;
+    });
+};
@@ -0,0 +1,112 @@
+// WebSocket (TCP) 探测通道：WebRTC 无法连接时的回退方案，也可与 DataChannel 同时运行对比
+
+// 由测试节点地址推导探测通道地址（与信令同源的 /ws/probe）
+const probeUrlFor = (nodeUrl) => {
+    const url = new URL(nodeUrl, window.location.href);
+    if (url.protocol === 'https:') {
+        url.protocol = 'wss:';
+    } else if (url.protocol === 'http:') {
+        url.protocol = 'ws:';
+    }
+    url.pathname = '/ws/probe';
+    url.search = '';
+    return url.toString();
+};
+
+class WebSocketProbe {
+    // options: { frequency, timeoutMs, onSession(id), onReport(report) }
+    constructor(nodeUrl, options) {
+        this.url = probeUrlFor(nodeUrl);
+        this.options = options;
+        this.pending = {};
+        this.seq = 0;
+        this.cursor = 0;
+        this.sessionId = null;
+        this.outstanding = 0; // 已上报但尚未收到结果的批次
+        this.stopping = false;
+    }
+
+    start() {
+        this.socket = new WebSocket(this.url);
+        this.socket.onopen = () => {
+            const intervalMs = 1000 / this.options.frequency;
+            this.sendTimer = setInterval(() => this.sendProbe(), intervalMs);
+            this.flushTimer = setInterval(() => this.flush(false), 500);
+        };
+        this.socket.onmessage = (event) => this.handleMessage(event.data);
+        this.socket.onclose = () => this.clearTimers();
+    }
+
+    // 停止发送，上报剩余样本后关闭（等待服务端回传最终结果）
+    stop() {
+        this.clearTimers();
+        if (!this.socket || this.socket.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        this.stopping = true;
+        this.flush(true);
+        this.closeTimer = setTimeout(() => this.socket.close(), 2000);
+    }
+
+    clearTimers() {
+        clearInterval(this.sendTimer);
+        clearInterval(this.flushTimer);
+    }
+
+    sendProbe() {
+        if (this.socket.readyState !== WebSocket.OPEN) return;
+        const timestamp = performance.now();
+        this.pending[this.seq] = { sentTime: timestamp, received: false };
+        this.socket.send(`${this.seq},${timestamp}`);
+        this.seq++;
+    }
+
+    handleMessage(data) {
+        const receiveTime = performance.now();
+        if (data.startsWith('{')) {
+            const message = JSON.parse(data);
+            if (message.type === 'session') {
+                this.sessionId = message.id;
+                if (this.options.onSession) this.options.onSession(message.id);
+            } else if (message.type === 'metrics') {
+                this.outstanding--;
+                if (this.options.onReport) this.options.onReport(message.report);
+                if (this.stopping && this.outstanding <= 0) {
+                    clearTimeout(this.closeTimer);
+                    this.socket.close();
+                }
+            }
+            return;
+        }
+
+        // seq,clientTs,serverRecv,serverSend —— 扣除服务端处理时间
+        const [seq, , serverRecv, serverSend] = data.split(',');
+        const entry = this.pending[seq];
+        if (entry && !entry.received) {
+            entry.received = true;
+            entry.receivedTime = receiveTime - (parseFloat(serverSend) - parseFloat(serverRecv));
+        }
+    }
+
+    // 与 DataChannel 路径相同的上报格式，由服务端统一计算指标
+    flush(final) {
+        if (this.socket.readyState !== WebSocket.OPEN) return;
+        const cutoff = performance.now() - this.options.timeoutMs;
+        const samples = [];
+        while (this.cursor < this.seq) {
+            const entry = this.pending[this.cursor];
+            if (!entry.received && !final && entry.sentTime > cutoff) {
+                break;
+            }
+            samples.push(entry.received
+                ? { seq: this.cursor, sent: entry.sentTime, received: entry.receivedTime }
+                : { seq: this.cursor, sent: entry.sentTime, lost: true });
+            delete this.pending[this.cursor];
+            this.cursor++;
+        }
+        if (samples.length > 0 || final) {
+            this.outstanding++;
+            this.socket.send(JSON.stringify({ type: 'samples', samples, final }));
+        }
+    }
+}
@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"pltester/datachannel"
+	"pltester/session"
+)
+
+// drainPollInterval 排空期间检查会话是否结束的间隔
+const drainPollInterval = 200 * time.Millisecond
+
+// Drain 节点关闭前排空会话：拒绝新会话，通知进行中的客户端在 ctx 截止前结束测试，
+// 客户端上报最终结果后即结束其会话；ctx 到期时结束剩余会话并关闭所有 PeerConnection
+func Drain(ctx context.Context) {
+	connManager.draining.Store(true)
+	deadline, _ := ctx.Deadline()
+
+	sessions := connManager.activeSessions()
+	probes := connManager.activeProbes()
+	log.Printf("Draining %d sessions and %d probe channels", len(sessions), len(probes))
+	for _, ps := range sessions {
+		sendJSON(ps, drainingMessage{Type: msgTypeDraining, Deadline: deadline})
+	}
+	for probe := range probes {
+		sendJSON(probe, drainingMessage{Type: msgTypeDraining, Deadline: deadline})
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		sessions, probes = connManager.activeSessions(), connManager.activeProbes()
+		if len(sessions) == 0 && len(probes) == 0 {
+			break
+		}
+		expired := false
+		select {
+		case <-ctx.Done():
+			expired = true
+		case <-ticker.C:
+		}
+		for _, ps := range sessions {
+			if expired || ps.sess.Complete() {
+				ps.end(closeShutdown)
+			}
+		}
+		for probe, sess := range probes {
+			if expired || sess.Complete() {
+				probe.Close()
+			}
+		}
+		if expired {
+			break
+		}
+	}
+
+	// 兜底：关闭仍登记的 PeerConnection
+	connManager.mutex.Lock()
+	for id, pc := range connManager.connections {
+		pc.Close()
+		delete(connManager.connections, id)
+	}
+	connManager.mutex.Unlock()
+}
+
+// activeSessions 返回进行中的会话
+func (cm *ConnectionManager) activeSessions() []*peerSession {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	sessions := make([]*peerSession, 0, len(cm.resumable))
+	for _, ps := range cm.resumable {
+		sessions = append(sessions, ps)
+	}
+	return sessions
+}
+
+// probeChannel 不经 PeerConnection 的探测通道（WebSocket、QUIC），
+// 排空时经 Send 通知客户端，到期后 Close
+type probeChannel interface {
+	datachannel.Signaler
+	Close() error
+}
+
+// registerProbe 登记探测通道，节点关闭时一并排空
+func (cm *ConnectionManager) registerProbe(probe probeChannel, sess *session.Session) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.probes[probe] = sess
+}
+
+func (cm *ConnectionManager) unregisterProbe(probe probeChannel) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	delete(cm.probes, probe)
+}
+
+// activeProbes 返回进行中的探测通道及其会话
+func (cm *ConnectionManager) activeProbes() map[probeChannel]*session.Session {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	probes := make(map[probeChannel]*session.Session, len(cm.probes))
+	for probe, sess := range cm.probes {
+		probes[probe] = sess
+	}
+	return probes
+}
@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// 协议层保活：服务端定期发送 ping 帧，浏览器和 x/net 客户端自动回复 pong。
+// nginx、Cloudflare 等反向代理会关闭一段时间没有数据的连接，ping 使连接保持活跃；
+// 超过 keepaliveTimeout 没有读到任何数据（包括 pong）即认为对端已失联，
+// 半开连接（如手机切换网络后旧连接）在数秒内被发现，而不必等待 TCP 超时
+const (
+	keepaliveInterval = 5 * time.Second
+	keepaliveTimeout  = 15 * time.Second
+)
+
+// pingCodec 发送空的 ping 帧，经 Codec 发送可与其他消息共用写锁
+var pingCodec = websocket.Codec{
+	Marshal: func(interface{}) ([]byte, byte, error) {
+		return nil, websocket.PingFrame, nil
+	},
+}
+
+type livenessKey struct{}
+
+// liveness 记录连接最近一次读到数据的时间。x/net/websocket 在 Receive 内部处理 pong，
+// 不会通知调用方，因此在劫持的连接上统计读取
+type liveness struct {
+	lastRead atomic.Int64 // Unix 纳秒
+}
+
+func (l *liveness) touch() {
+	l.lastRead.Store(time.Now().UnixNano())
+}
+
+func (l *liveness) idle() time.Duration {
+	return time.Since(time.Unix(0, l.lastRead.Load()))
+}
+
+// livenessReader 每次读到数据时更新 liveness
+type livenessReader struct {
+	r io.Reader
+	l *liveness
+}
+
+func (r livenessReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.touch()
+	}
+	return n, err
+}
+
+// livenessWriter 在 WebSocket 握手劫持连接时插入 livenessReader
+type livenessWriter struct {
+	http.ResponseWriter
+	l *liveness
+}
+
+func (w livenessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.l.touch()
+	reader := bufio.NewReader(livenessReader{r: rw.Reader, l: w.l})
+	return conn, bufio.NewReadWriter(reader, rw.Writer), nil
+}
+
+// KeepaliveHandler 包装 WebSocket 处理器，记录连接的读取活动，供保活检测失联的对端
+func KeepaliveHandler(h websocket.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := &liveness{}
+		ctx := context.WithValue(r.Context(), livenessKey{}, l)
+		h.ServeHTTP(livenessWriter{ResponseWriter: w, l: l}, r.WithContext(ctx))
+	})
+}
+
+// keepalive 定期向连接发送 ping，对端超过 keepaliveTimeout 没有任何数据时让阻塞中的 Receive
+// 以超时错误返回。返回的函数用于停止保活
+func keepalive(ws *websocket.Conn) (stop func()) {
+	l, _ := ws.Request().Context().Value(livenessKey{}).(*liveness)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			// 未经 KeepaliveHandler 包装时只发送 ping，无法检测失联
+			if l != nil && l.idle() > keepaliveTimeout {
+				log.Printf("No data from %s for %s, closing dead connection", ws.Request().RemoteAddr, keepaliveTimeout)
+				ws.SetReadDeadline(time.Now())
+				return
+			}
+			if err := pingCodec.Send(ws, nil); err != nil {
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
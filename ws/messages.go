@@ -0,0 +1,235 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"pltester/datachannel"
+	"pltester/impair"
+	"pltester/metrics"
+	"pltester/nat"
+	"pltester/session"
+)
+
+// 信令消息类型（SDP 使用 offer/answer，ICE 候选不带 type 字段）
+const (
+	msgTypeSession        = "session"
+	msgTypeSamples        = "samples"
+	msgTypeMetrics        = "metrics"
+	msgTypeTimeSeries     = "timeseries"
+	msgTypeOutages        = "outages"
+	msgTypeMedia          = "media"
+	msgTypeMediaStats     = "mediastats"
+	msgTypeBWE            = "bwe"
+	msgTypeBWEStats       = "bwestats"
+	msgTypeChannels       = "channels"
+	msgTypeChannelMetrics = "channelmetrics"
+	msgTypeThroughput     = "throughput"
+	msgTypeThroughputDone = "throughputresult"
+	msgTypeImpair         = "impair"
+	msgTypeImpairStats    = "impairstats"
+	msgTypeNAT            = "nat"
+	msgTypeTransport      = "transport"
+	msgTypeServerOffer    = "serveroffer" // 客户端请求由服务端创建探测通道并发送 offer
+	msgTypeICERestart     = "icerestart"  // 客户端请求由服务端发起 ICE 重启
+	msgTypeResume         = "resume"
+	msgTypeInterruption   = "interruption"
+	msgTypeClosed         = "closed"
+	msgTypeDraining       = "draining"
+	msgTypeError          = "error"
+)
+
+// resultNetwork 会话结果中记录双栈对比测试限定的地址族
+const resultNetwork = "network"
+
+// resultCloseReason 会话结果中记录服务端主动结束会话的原因
+const resultCloseReason = "closeReason"
+
+// resultQUICClient 会话结果中记录 QUIC 探测的接入方式（quic 或 webtransport）
+const resultQUICClient = "quicClient"
+
+// 服务端主动结束会话的原因
+const (
+	closeFailed             = "failed"              // PeerConnection 连接失败且未能恢复
+	closeNegotiationTimeout = "negotiation-timeout" // 超时未建立连接
+	closeIdle               = "idle"                // 长时间没有信令消息和数据通道流量
+	closeShutdown           = "shutdown"            // 节点关闭
+)
+
+// signalEnvelope 用于在分发前识别消息类型
+type signalEnvelope struct {
+	Type string `json:"type"`
+}
+
+// sessionMessage 告知客户端本次测试的会话ID，启用内置 STUN 服务器时附带其地址用于 NAT 检测。
+// 信令断开后客户端以 resume=ResumeToken 重新连接即可继续本次测试
+type sessionMessage struct {
+	Type        string    `json:"type"`
+	ID          string    `json:"id"`
+	STUN        *nat.URLs `json:"stun,omitempty"`
+	ResumeToken string    `json:"resumeToken,omitempty"`
+}
+
+// resumeMessage 重连的结果，Resumed 为 false 表示令牌未知或会话已超过宽限期结束
+type resumeMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Resumed bool   `json:"resumed"`
+}
+
+// interruptionMessage 会话从信令断开或 ICE 断开中恢复后通知客户端
+type interruptionMessage struct {
+	Type         string               `json:"type"`
+	Interruption session.Interruption `json:"interruption"`
+}
+
+// samplesMessage 客户端上报的一批探测包收发时间，Final 表示测试结束。
+// Channel 非空时为可靠性对比测试中对应通道的样本，Final 表示该通道结束
+type samplesMessage struct {
+	Type    string           `json:"type"`
+	Channel string           `json:"channel,omitempty"`
+	Samples []metrics.Sample `json:"samples"`
+	Final   bool             `json:"final,omitempty"`
+}
+
+// metricsMessage 服务端计算出的测试结果
+type metricsMessage struct {
+	Type   string         `json:"type"`
+	Report metrics.Report `json:"report"`
+}
+
+// timeSeriesMessage 新关闭的时间序列桶
+type timeSeriesMessage struct {
+	Type    string           `json:"type"`
+	Buckets []session.Bucket `json:"buckets"`
+}
+
+// outagesMessage 新检测到的连接中断事件
+type outagesMessage struct {
+	Type    string           `json:"type"`
+	Outages []session.Outage `json:"outages"`
+}
+
+// mediaMessage 客户端请求 RTP 媒体流测试
+type mediaMessage struct {
+	Type string `json:"type"`
+	datachannel.MediaOptions
+}
+
+// mediaStatsMessage 周期性推送的 RTP 流统计
+type mediaStatsMessage struct {
+	Type   string                  `json:"type"`
+	Report datachannel.MediaReport `json:"report"`
+}
+
+// bweMessage 客户端请求带宽估计测试
+type bweMessage struct {
+	Type string `json:"type"`
+	datachannel.BWEOptions
+}
+
+// bweStatsMessage 周期性推送的最新估计值和各码率档位汇总
+type bweStatsMessage struct {
+	Type   string                `json:"type"`
+	Point  datachannel.BWEPoint  `json:"point"`
+	Report datachannel.BWEReport `json:"report"`
+}
+
+// channelsMessage 客户端请求可靠性对比测试（LifetimeMs 为限时重传通道的参数），
+// 服务端以同一类型回复实际创建的预协商通道配置
+type channelsMessage struct {
+	Type       string                           `json:"type"`
+	LifetimeMs int                              `json:"lifetimeMs,omitempty"`
+	Profiles   []datachannel.ReliabilityProfile `json:"profiles,omitempty"`
+}
+
+// channelMetricsMessage 各可靠性配置通道的送达率和相对不重传通道的延迟增加
+type channelMetricsMessage struct {
+	Type     string                  `json:"type"`
+	Channels []session.ChannelReport `json:"channels"`
+}
+
+// throughputMessage 客户端请求 DataChannel 吞吐量测试；服务端以同一类型回复通道 ID（phase 为 setup），
+// 并在每个方向开始时通知客户端（phase 为 download / upload）
+type throughputMessage struct {
+	Type   string `json:"type"`
+	Phase  string `json:"phase,omitempty"`
+	BulkID uint16 `json:"bulkId,omitempty"`
+	PingID uint16 `json:"pingId,omitempty"`
+	datachannel.ThroughputOptions
+}
+
+// throughputResultMessage 吞吐量测试结果
+type throughputResultMessage struct {
+	Type   string                       `json:"type"`
+	Result datachannel.ThroughputResult `json:"result"`
+}
+
+// impairMessage 客户端为本会话配置回显路径的网络损伤（须在 offer 之前发送，协商开始后的设置被拒绝），
+// 服务端以同一类型回复生效的配置
+type impairMessage struct {
+	Type   string        `json:"type"`
+	Config impair.Config `json:"config"`
+}
+
+// impairStatsMessage 服务端实际注入的损伤，作为客户端测量结果的对照
+type impairStatsMessage struct {
+	Type   string        `json:"type"`
+	Report impair.Report `json:"report"`
+}
+
+// natMessage 客户端上报向内置 STUN 服务器各地址收集到的 srflx 候选，
+// 服务端以同一类型回复 NAT 映射行为的判断
+type natMessage struct {
+	Type       string          `json:"type"`
+	Candidates []nat.Candidate `json:"candidates,omitempty"`
+	Report     *nat.Report     `json:"report,omitempty"`
+}
+
+// ICE 连接结果
+const (
+	transportConnected = "connected"
+	transportFailed    = "failed"
+)
+
+// transportResult 会话的传输策略及 ICE 连接结果，成功时附带选中的候选对
+type transportResult struct {
+	Transport string            `json:"transport,omitempty"`
+	State     string            `json:"state"`
+	Path      *datachannel.Path `json:"path,omitempty"`
+}
+
+// transportMessage 服务端在 ICE 连接成功或失败时通知客户端
+type transportMessage struct {
+	Type string `json:"type"`
+	transportResult
+}
+
+// closedMessage 服务端结束会话前告知客户端原因，此后会话不可恢复
+type closedMessage struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// drainingMessage 节点即将关闭，客户端应在 Deadline 前结束测试并上报最终结果
+type drainingMessage struct {
+	Type     string    `json:"type"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// errorMessage 服务端拒绝了客户端的请求（如参数超出范围），Request 为被拒绝请求的类型，会话继续进行
+type errorMessage struct {
+	Type    string `json:"type"`
+	Request string `json:"request"`
+	Error   string `json:"error"`
+}
+
+// sendJSON 通过信令通道发送 JSON 消息
+func sendJSON(sig datachannel.Signaler, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return sig.Send(string(data))
+}
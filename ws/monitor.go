@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// 会话超时的默认值
+const (
+	defaultNegotiationTimeout = 30 * time.Second
+	defaultIdleTimeout        = 2 * time.Minute
+	monitorInterval           = 5 * time.Second
+)
+
+// SetSessionTimeouts 设置会话建立连接的超时和空闲超时，0 表示使用默认值
+func SetSessionTimeouts(negotiation, idle time.Duration) {
+	if negotiation <= 0 {
+		negotiation = defaultNegotiationTimeout
+	}
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	connManager.negotiationTimeout = negotiation
+	connManager.idleTimeout = idle
+}
+
+// watchConnection 记录 PeerConnection 的状态变化，并结束以下会话，避免泄漏的 PeerConnection
+// 长期占用内存和端口：从未连接成功即失败的、超时仍未建立连接的、连接后失败且宽限期内未能
+// 通过 ICE 重启恢复的，以及长时间既没有信令消息也没有数据通道流量的
+func (ps *peerSession) watchConnection() {
+	var (
+		mu        sync.Mutex
+		connected bool
+		failedAt  time.Time
+	)
+	ps.peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		ps.sess.AddStateChange(state.String())
+		mu.Lock()
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connected = true
+			failedAt = time.Time{}
+		case webrtc.PeerConnectionStateFailed:
+			failedAt = time.Now()
+		}
+		everConnected := connected
+		mu.Unlock()
+
+		if state == webrtc.PeerConnectionStateFailed && !everConnected {
+			// 在回调之外关闭 PeerConnection
+			go ps.end(closeFailed)
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(monitorInterval)
+		defer ticker.Stop()
+		var received uint64
+		active := time.Now()
+		for {
+			select {
+			case <-ps.done:
+				return
+			case <-ticker.C:
+			}
+			now := time.Now()
+			if n := ps.peer.BytesReceived(); n != received {
+				received = n
+				active = now
+			}
+			ps.mu.Lock()
+			if ps.lastSeen.After(active) {
+				active = ps.lastSeen
+			}
+			ps.mu.Unlock()
+
+			mu.Lock()
+			everConnected, failed := connected, failedAt
+			mu.Unlock()
+
+			switch {
+			case !everConnected && now.Sub(ps.sess.StartedAt) > connManager.negotiationTimeout:
+				ps.end(closeNegotiationTimeout)
+				return
+			case !failed.IsZero() && now.Sub(failed) > connManager.resumeGrace:
+				ps.end(closeFailed)
+				return
+			case now.Sub(active) > connManager.idleTimeout:
+				ps.end(closeIdle)
+				return
+			}
+		}
+	}()
+}
+
+// end 由服务端主动结束会话，先告知客户端原因
+func (ps *peerSession) end(reason string) {
+	ps.mu.Lock()
+	closed := ps.closed
+	ps.mu.Unlock()
+	if closed {
+		return
+	}
+	log.Printf("Closing session %s: %s", ps.sess.ID, reason)
+	ps.sess.SetResult(resultCloseReason, reason)
+	sendJSON(ps, closedMessage{Type: msgTypeClosed, Reason: reason})
+	ps.close()
+}
+
+// peerStats 当前各状态的 PeerConnection 数量，用于发现泄漏
+func (cm *ConnectionManager) peerStats() map[string]int {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	counts := make(map[string]int)
+	for _, pc := range cm.connections {
+		counts[pc.ConnectionState().String()]++
+	}
+	return counts
+}
@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pltester/datachannel"
+	"pltester/session"
+
+	"golang.org/x/net/websocket"
+)
+
+// maxProbeFrame 探测通道单帧大小上限
+const maxProbeFrame = 1024 * 1024
+
+// ProbeHandler 通过 WebSocket 回显探测帧，作为 WebRTC 无法连接时的 TCP 回退通道，
+// 也可与 DataChannel 同时运行以对比 TCP 队头阻塞的影响。
+//
+// 探测帧为文本 "seq,clientTs"，回显为 "seq,clientTs,serverRecv,serverSend"，
+// 服务端时间戳为 Unix 毫秒，客户端可据此扣除服务端处理时间。
+// 以 "{" 开头的帧是与信令通道相同格式的 JSON 控制消息（samples 上报）。
+func ProbeHandler(ws *websocket.Conn) {
+	defer ws.Close()
+
+	if !validateOrigin(ws.Request()) {
+		log.Printf("Invalid origin from %s", ws.Request().RemoteAddr)
+		ws.WriteClose(http.StatusForbidden)
+		return
+	}
+	if connManager.draining.Load() {
+		log.Printf("Rejecting probe channel from %s: server is shutting down", ws.Request().RemoteAddr)
+		ws.WriteClose(http.StatusServiceUnavailable)
+		return
+	}
+	ws.MaxPayloadBytes = maxProbeFrame
+
+	sig := datachannel.WebSocketSignaler{Conn: ws}
+	sess := connManager.sessions.Create(session.TransportWebSocket)
+	defer sess.Finish()
+	connManager.registerProbe(sig, sess)
+	defer connManager.unregisterProbe(sig)
+	if err := sendJSON(sig, sessionMessage{Type: msgTypeSession, ID: sess.ID}); err != nil {
+		log.Printf("Failed to send session ID for probe %s: %v", sess.ID, err)
+		return
+	}
+	log.Println("WebSocket probe channel opened for session:", sess.ID)
+
+	defer keepalive(ws)()
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			log.Printf("Probe channel %s closed: %v", sess.ID, err)
+			return
+		}
+		received := time.Now()
+
+		if strings.HasPrefix(msg, "{") {
+			var envelope signalEnvelope
+			if err := json.Unmarshal([]byte(msg), &envelope); err != nil || envelope.Type != msgTypeSamples {
+				log.Printf("Invalid probe control message from %s", sess.ID)
+				return
+			}
+			if err := handleSamples(sess, msg, sig, nil); err != nil {
+				log.Printf("Failed to handle samples for %s: %v", sess.ID, err)
+				return
+			}
+			continue
+		}
+
+		reply := make([]byte, 0, len(msg)+40)
+		reply = append(reply, msg...)
+		reply = append(reply, ',')
+		reply = strconv.AppendFloat(reply, unixMillis(received), 'f', 3, 64)
+		reply = append(reply, ',')
+		reply = strconv.AppendFloat(reply, unixMillis(time.Now()), 'f', 3, 64)
+		if err := websocket.Message.Send(ws, string(reply)); err != nil {
+			log.Printf("Failed to echo probe for %s: %v", sess.ID, err)
+			return
+		}
+	}
+}
+
+// unixMillis 返回 Unix 毫秒时间戳
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
@@ -0,0 +1,188 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pltester/impair"
+	"pltester/session"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+)
+
+// QUICProbeALPN 原生 QUIC 探测连接的 ALPN 标识，与 HTTP/3 (h3) 共用同一 UDP 端口
+const QUICProbeALPN = "pltester-probe"
+
+// quicControlTimeout 打开控制流的超时
+const quicControlTimeout = 10 * time.Second
+
+// QUIC 连接关闭时的应用错误码
+const (
+	quicCloseNormal   = 0
+	quicCloseRejected = 1
+)
+
+// datagramConn 原生 QUIC 连接和 WebTransport 会话共有的不可靠数据报接口
+type datagramConn interface {
+	SendDatagram([]byte) error
+	ReceiveDatagram(context.Context) ([]byte, error)
+	Context() context.Context
+}
+
+// quicProbe 基于 QUIC DATAGRAM 的探测通道：探测帧以不重传的数据报回显，
+// 控制消息经服务端打开的双向流以换行分隔的 JSON 传输，格式与信令通道相同
+type quicProbe struct {
+	conn   datagramConn
+	stream io.ReadWriteCloser
+	close  func(code uint64, reason string) error
+	sess   *session.Session
+	link   atomic.Pointer[impair.Link]
+
+	mu sync.Mutex // 保护 stream 写入
+}
+
+// Send 在控制流上发送一条消息
+func (p *quicProbe) Send(msg string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.stream, msg+"\n")
+	return err
+}
+
+// Close 关闭整个连接，客户端的控制流和数据报随之结束
+func (p *quicProbe) Close() error {
+	return p.close(quicCloseNormal, "closed by node")
+}
+
+// ServeQUICProbe 处理以 QUICProbeALPN 建立的原生 QUIC 连接，供 pltcli 等脚本化客户端使用
+func ServeQUICProbe(conn quic.Connection) {
+	closeConn := func(code uint64, reason string) error {
+		return conn.CloseWithError(quic.ApplicationErrorCode(code), reason)
+	}
+	if connManager.draining.Load() {
+		log.Printf("Rejecting QUIC probe from %s: server is shutting down", conn.RemoteAddr())
+		closeConn(quicCloseRejected, "server is shutting down")
+		return
+	}
+	ctx, cancel := context.WithTimeout(conn.Context(), quicControlTimeout)
+	stream, err := conn.OpenStreamSync(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to open QUIC control stream to %s: %v", conn.RemoteAddr(), err)
+		closeConn(quicCloseRejected, "no control stream")
+		return
+	}
+	serveQUICProbe(conn, stream, closeConn, conn.RemoteAddr().String(), "quic")
+}
+
+// WebTransportHandler 将请求升级为 WebTransport 会话，浏览器通过 QUIC DATAGRAM 运行与
+// DataChannel 相同的丢包/延迟测试，无需 WebRTC 协商
+func WebTransportHandler(server *webtransport.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if connManager.draining.Load() {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		wt, err := server.Upgrade(w, r)
+		if err != nil {
+			log.Printf("WebTransport upgrade failed for %s: %v", r.RemoteAddr, err)
+			http.Error(w, "WebTransport upgrade failed", http.StatusBadRequest)
+			return
+		}
+		closeSession := func(code uint64, reason string) error {
+			return wt.CloseWithError(webtransport.SessionErrorCode(code), reason)
+		}
+		ctx, cancel := context.WithTimeout(wt.Context(), quicControlTimeout)
+		stream, err := wt.OpenStreamSync(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to open WebTransport control stream to %s: %v", r.RemoteAddr, err)
+			closeSession(quicCloseRejected, "no control stream")
+			return
+		}
+		serveQUICProbe(wt, stream, closeSession, r.RemoteAddr, "webtransport")
+	}
+}
+
+func serveQUICProbe(conn datagramConn, stream io.ReadWriteCloser, closeConn func(uint64, string) error, remote, client string) {
+	p := &quicProbe{
+		conn:   conn,
+		stream: stream,
+		close:  closeConn,
+		sess:   connManager.sessions.Create(session.TransportQUIC),
+	}
+	defer p.sess.Finish()
+	p.link.Store(impair.NewLink(connManager.impairment))
+	defer func() { p.link.Load().Close() }()
+	defer closeConn(quicCloseNormal, "")
+
+	p.sess.SetResult(resultQUICClient, client)
+	connManager.registerProbe(p, p.sess)
+	defer connManager.unregisterProbe(p)
+	if err := sendJSON(p, sessionMessage{Type: msgTypeSession, ID: p.sess.ID}); err != nil {
+		log.Printf("Failed to send session ID for QUIC probe %s: %v", p.sess.ID, err)
+		return
+	}
+	log.Printf("QUIC probe (%s) opened for session %s from %s", client, p.sess.ID, remote)
+
+	go p.echo()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 4096), maxSignalMessage)
+	for scanner.Scan() {
+		msg := scanner.Text()
+		var envelope signalEnvelope
+		if err := json.Unmarshal([]byte(msg), &envelope); err != nil {
+			log.Printf("Invalid QUIC probe control message from %s", p.sess.ID)
+			return
+		}
+		switch envelope.Type {
+		case msgTypeSamples:
+			if err := handleSamples(p.sess, msg, p, p.link.Load()); err != nil {
+				log.Printf("Failed to handle samples for %s: %v", p.sess.ID, err)
+				return
+			}
+		case msgTypeImpair:
+			l, err := handleImpair(msg, p)
+			if err != nil {
+				if err = replyRejected(p, envelope.Type, p.sess.ID, err); err != nil {
+					log.Printf("Failed to configure impairment for %s: %v", p.sess.ID, err)
+					return
+				}
+				continue
+			}
+			// 回显每条数据报时读取当前的 link，被替换的 link 中仍在延迟的数据报随之丢弃
+			p.link.Swap(l).Close()
+		default:
+			log.Printf("Unexpected QUIC probe control message %q from %s", envelope.Type, p.sess.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("QUIC probe %s closed: %v", p.sess.ID, err)
+		return
+	}
+	log.Printf("QUIC probe %s closed", p.sess.ID)
+}
+
+// echo 原样回显收到的数据报，经过本会话模拟的网络损伤。超过路径 MTU 的回显发送失败，
+// 客户端将其计为丢包
+func (p *quicProbe) echo() {
+	ctx := p.conn.Context()
+	for {
+		msg, err := p.conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		p.link.Load().Send(len(msg), func() {
+			p.conn.SendDatagram(msg)
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pltester/datachannel"
+	"pltester/impair"
+	"pltester/nat"
+	"pltester/session"
+
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/net/websocket"
+)
+
+// defaultResumeGrace 信令断开后保留会话、等待客户端重连的默认时长
+const defaultResumeGrace = 30 * time.Second
+
+// errDetached 信令断开期间发送的消息被丢弃
+var errDetached = errors.New("signaling connection detached")
+
+// rejectedError 客户端请求无法执行（参数无效、重复请求等）：回复错误消息，会话继续进行。
+// 其余错误说明信令本身异常，结束会话
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+// reject 将错误标记为拒绝请求
+func reject(err error) error {
+	return &rejectedError{err: err}
+}
+
+// replyRejected 请求被拒绝时回复错误消息并返回 nil，其余错误原样返回
+func replyRejected(sig datachannel.Signaler, request string, connID string, err error) error {
+	var rejected *rejectedError
+	if !errors.As(err, &rejected) {
+		return err
+	}
+	log.Printf("Rejected %s request from %s: %v", request, connID, err)
+	return sendJSON(sig, errorMessage{Type: msgTypeError, Request: request, Error: rejected.Error()})
+}
+
+// SetResumeGrace 设置信令断开后等待客户端凭恢复令牌重连的宽限期，0 表示使用默认值
+func SetResumeGrace(d time.Duration) {
+	if d <= 0 {
+		d = defaultResumeGrace
+	}
+	connManager.resumeGrace = d
+}
+
+// peerSession 一次测试的服务端状态。信令 WebSocket 意外断开（如手机从 Wi-Fi 切换到蜂窝网络）后
+// 在宽限期内保留，客户端凭恢复令牌重连即可接管，PeerConnection 和会话记录不受影响
+type peerSession struct {
+	token     string
+	peer      *datachannel.Peer
+	sess      *session.Session
+	stunURLs  *nat.URLs
+	transport string
+	// link 回显路径的网络损伤：默认使用全局配置，客户端可通过 impair 消息替换
+	link atomic.Pointer[impair.Link]
+	// done 在会话结束时关闭，用于停止后台任务
+	done chan struct{}
+
+	mu         sync.Mutex
+	conn       *websocket.Conn // 当前信令连接，断开期间为 nil
+	lastSeen   time.Time       // 最近一次收到信令消息的时间
+	detachedAt time.Time
+	grace      *time.Timer
+	closed     bool
+}
+
+func newPeerSession(ws *websocket.Conn, peer *datachannel.Peer, sess *session.Session, stunURLs *nat.URLs, transport string) *peerSession {
+	ps := &peerSession{
+		token:     newResumeToken(),
+		peer:      peer,
+		sess:      sess,
+		stunURLs:  stunURLs,
+		transport: transport,
+		done:      make(chan struct{}),
+		conn:      ws,
+		lastSeen:  time.Now(),
+	}
+	ps.link.Store(impair.NewLink(connManager.impairment))
+	return ps
+}
+
+// Send 发往当前信令连接，断开期间返回 errDetached
+func (ps *peerSession) Send(msg string) error {
+	ps.mu.Lock()
+	conn := ps.conn
+	ps.mu.Unlock()
+	if conn == nil {
+		return errDetached
+	}
+	return websocket.Message.Send(conn, msg)
+}
+
+// serve 处理一条信令连接上的消息，直到连接断开或被新的连接接管
+func (ps *peerSession) serve(ws *websocket.Conn) {
+	connID := ps.sess.ID
+	// 对端失联由协议层保活检测，空闲会话由 watchConnection 回收，因此不设读取超时
+	defer keepalive(ws)()
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				log.Printf("Message too large from %s", connID)
+				ws.WriteClose(http.StatusRequestEntityTooLarge)
+				ps.close()
+				return
+			}
+			ps.detach(ws, err)
+			return
+		}
+		ps.mu.Lock()
+		ps.lastSeen = time.Now()
+		ps.mu.Unlock()
+
+		var envelope signalEnvelope
+		if err := json.Unmarshal([]byte(msg), &envelope); err != nil {
+			log.Printf("Invalid signaling message from %s: %v", connID, err)
+			ps.close()
+			return
+		}
+		err := ps.handle(envelope.Type, msg)
+		if err = replyRejected(ps, envelope.Type, connID, err); err != nil {
+			log.Printf("Session %s: %v", connID, err)
+			ps.close()
+			return
+		}
+	}
+}
+
+// handle 分发一条信令消息。请求被拒绝时返回 rejectedError，其余错误结束会话
+func (ps *peerSession) handle(msgType, msg string) error {
+	peerConnection := ps.peer.PeerConnection
+	switch msgType {
+	case msgTypeSamples:
+		if err := handleSamples(ps.sess, msg, ps, ps.link.Load()); err != nil {
+			return fmt.Errorf("failed to handle samples: %w", err)
+		}
+	case msgTypeMedia:
+		if err := handleMedia(ps.peer, ps.sess, msg, ps, ps.done); err != nil {
+			return fmt.Errorf("failed to start media test: %w", err)
+		}
+	case msgTypeChannels:
+		if err := handleChannels(ps.peer, msg, ps, ps.sess.ID); err != nil {
+			return fmt.Errorf("failed to open comparison channels: %w", err)
+		}
+	case msgTypeThroughput:
+		if err := handleThroughput(ps.peer, ps.sess, msg, ps, ps.done); err != nil {
+			return fmt.Errorf("failed to start throughput test: %w", err)
+		}
+	case msgTypeBWE:
+		if err := handleBWE(ps.peer, ps.sess, msg, ps, ps.done); err != nil {
+			return fmt.Errorf("failed to start bandwidth estimation: %w", err)
+		}
+	case msgTypeNAT:
+		if err := handleNAT(ps.sess, msg, ps, ps.stunURLs); err != nil {
+			return fmt.Errorf("failed to classify NAT: %w", err)
+		}
+	case msgTypeServerOffer:
+		// 服务端发起协商：先注册回显再发送 offer，此前请求的媒体轨道和通道一并协商。
+		// 已协商时先拒绝，以免重复请求多创建一个探测通道
+		if err := datachannel.CheckOffer(peerConnection); err != nil {
+			return reject(err)
+		}
+		d, err := datachannel.NewProbeChannel(peerConnection)
+		if err != nil {
+			return err
+		}
+		datachannel.Echo(d, &connManager.echoStats, ps.link.Load(), ps.sess.ID)
+		if err := datachannel.SendOffer(peerConnection, ps); err != nil {
+			return err
+		}
+	case msgTypeICERestart:
+		// 由服务端发起 ICE 重启，供只会应答的客户端在网络切换后使用；
+		// 与客户端的协商冲突时忽略本次请求，不结束会话
+		if err := datachannel.RestartICE(peerConnection, ps); err != nil {
+			log.Printf("Failed to restart ICE for %s: %v", ps.sess.ID, err)
+		}
+	case msgTypeImpair:
+		// 回显通道在协商时绑定当时的 link，此后替换不会生效，因此只接受协商前的设置
+		if peerConnection.LocalDescription() != nil || peerConnection.RemoteDescription() != nil {
+			return reject(fmt.Errorf("impairment must be configured before negotiation"))
+		}
+		l, err := handleImpair(msg, ps)
+		if err != nil {
+			return fmt.Errorf("failed to configure impairment: %w", err)
+		}
+		// 被替换的 link 尚未被任何通道使用
+		ps.link.Swap(l).Close()
+	default:
+		// 包括客户端在 ICE 重启时发来的新 offer
+		if err := datachannel.HandleSDP(peerConnection, msg, ps); err != nil {
+			return fmt.Errorf("failed to handle SDP: %w", err)
+		}
+	}
+	return nil
+}
+
+// detach 信令连接断开后保留会话等待重连，超过宽限期后结束。
+// x/net/websocket 对关闭帧和 TCP 断开都返回 io.EOF，无法区分客户端是否有意关闭，因此一律等待宽限期
+func (ps *peerSession) detach(ws *websocket.Conn, err error) {
+	ps.mu.Lock()
+	if ps.closed || ps.conn != ws {
+		// 已结束或已被新连接接管
+		ps.mu.Unlock()
+		return
+	}
+	ps.conn = nil
+	ps.detachedAt = time.Now()
+	grace := connManager.resumeGrace
+	ps.grace = time.AfterFunc(grace, ps.expire)
+	ps.mu.Unlock()
+	log.Printf("Signaling for %s lost (%v), holding session for %s", ps.sess.ID, err, grace)
+}
+
+// attach 由重连的信令连接接管会话，记录信令中断事件；会话已结束时返回 false
+func (ps *peerSession) attach(ws *websocket.Conn) bool {
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return false
+	}
+	if ps.grace != nil {
+		ps.grace.Stop()
+		ps.grace = nil
+	}
+	old := ps.conn
+	start := ps.detachedAt
+	if old != nil {
+		// 服务端尚未察觉旧连接断开，以最后一次收到消息的时间作为中断开始
+		start = ps.lastSeen
+	}
+	ps.conn = ws
+	ps.detachedAt = time.Time{}
+	ps.lastSeen = time.Now()
+	ps.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	in := ps.sess.AddInterruption(session.InterruptionSignaling, start, time.Now(), "")
+	log.Printf("Session %s resumed after %.0f ms", ps.sess.ID, in.Duration)
+	sendJSON(ps, resumeMessage{Type: msgTypeResume, ID: ps.sess.ID, Resumed: true})
+	sendJSON(ps, interruptionMessage{Type: msgTypeInterruption, Interruption: in})
+	return true
+}
+
+// expire 宽限期内未重连时结束会话
+func (ps *peerSession) expire() {
+	ps.mu.Lock()
+	if ps.closed || ps.conn != nil {
+		ps.mu.Unlock()
+		return
+	}
+	ps.closed = true
+	ps.mu.Unlock()
+	log.Printf("Session %s was not resumed in time", ps.sess.ID)
+	ps.teardown(nil)
+}
+
+// close 结束会话
+func (ps *peerSession) close() {
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return
+	}
+	ps.closed = true
+	if ps.grace != nil {
+		ps.grace.Stop()
+	}
+	conn := ps.conn
+	ps.mu.Unlock()
+	ps.teardown(conn)
+}
+
+func (ps *peerSession) teardown(conn *websocket.Conn) {
+	close(ps.done)
+	if l := ps.link.Load(); l != nil {
+		ps.sess.SetResult(msgTypeImpair, l.Report())
+		l.Close()
+	}
+	connManager.unregisterConnection(ps.sess.ID)
+	connManager.forgetSession(ps.token)
+	ps.sess.Finish()
+	// 测试期间推送的是近似百分位，结束后以精确结果覆盖
+	if reports := ps.sess.ChannelReports(datachannel.ProfileUnreliable); len(reports) > 0 {
+		ps.sess.SetResult(msgTypeChannels, reports)
+	}
+	ps.peer.Close()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// watchICE 在 ICE 连接成功或失败时告知客户端并记入会话结果：成功时附带选中的候选对，
+// 失败说明该传输策略在客户端网络上不可用。连接建立后的断开和恢复（包括 ICE 重启）记为中断事件，
+// 恢复后重新上报路径，网络切换后路径通常已经改变
+func (ps *peerSession) watchICE() {
+	var (
+		mu        sync.Mutex
+		connected bool
+		failed    bool
+		lostAt    time.Time
+	)
+	report := func(state string, path *datachannel.Path) {
+		result := transportResult{Transport: ps.transport, State: state, Path: path}
+		ps.sess.SetResult(msgTypeTransport, result)
+		if err := sendJSON(ps, transportMessage{Type: msgTypeTransport, transportResult: result}); err != nil {
+			log.Printf("Failed to send transport state for %s: %v", ps.sess.ID, err)
+		}
+	}
+	ps.peer.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
+			path := ps.peer.SelectedPath()
+			if path != nil {
+				log.Printf("Session %s connected over %s %s -> %s (transport policy %q)",
+					ps.sess.ID, path.Protocol, path.LocalType, path.RemoteType, ps.transport)
+			}
+			if !lostAt.IsZero() {
+				var detail string
+				if path != nil {
+					detail = fmt.Sprintf("%s %s -> %s", path.Protocol, path.LocalType, path.RemoteType)
+				}
+				in := ps.sess.AddInterruption(session.InterruptionICE, lostAt, time.Now(), detail)
+				log.Printf("Session %s ICE recovered after %.0f ms", ps.sess.ID, in.Duration)
+				sendJSON(ps, interruptionMessage{Type: msgTypeInterruption, Interruption: in})
+				lostAt = time.Time{}
+			}
+			connected = true
+			report(transportConnected, path)
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed:
+			if connected {
+				// 等待自行恢复或客户端发起 ICE 重启
+				if lostAt.IsZero() {
+					lostAt = time.Now()
+					log.Printf("Session %s ICE %s", ps.sess.ID, state)
+				}
+				return
+			}
+			if state == webrtc.ICEConnectionStateFailed && !failed {
+				failed = true
+				log.Printf("Session %s failed to connect (transport policy %q)", ps.sess.ID, ps.transport)
+				report(transportFailed, nil)
+			}
+		}
+	})
+}
+
+// resumeSession 按恢复令牌查找会话并由新连接接管，令牌未知或会话已结束时返回 nil
+func (cm *ConnectionManager) resumeSession(token string, ws *websocket.Conn) *peerSession {
+	cm.mutex.RLock()
+	ps := cm.resumable[token]
+	cm.mutex.RUnlock()
+	if ps == nil || !ps.attach(ws) {
+		return nil
+	}
+	return ps
+}
+
+// registerSession 登记可恢复的会话
+func (cm *ConnectionManager) registerSession(ps *peerSession) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.resumable[ps.token] = ps
+}
+
+// forgetSession 会话结束后令牌失效
+func (cm *ConnectionManager) forgetSession(token string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	delete(cm.resumable, token)
+}
+
+// newResumeToken 生成不可猜测的恢复令牌，持有令牌即可接管会话
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to generate resume token: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
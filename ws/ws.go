@@ -1,13 +1,21 @@
 package ws
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"pltester/datachannel"
+	"pltester/impair"
+	"pltester/nat"
+	"pltester/session"
 
+	"github.com/pion/ice/v2"
 	"github.com/pion/webrtc/v3"
 	"golang.org/x/net/websocket"
 )
@@ -19,10 +27,32 @@ type ConnectionManager struct {
 	publicIP    string // 公网IP配置
 	udpPortMin  uint16 // UDP端口范围最小值
 	udpPortMax  uint16 // UDP端口范围最大值
+	sessions    *session.Store
+	echoStats   datachannel.EchoStats
+	impairment  impair.Config           // 全局网络损伤配置，会话可自行替换
+	stun        *nat.Server             // 内置 STUN 服务器，未启用时为 nil
+	turn        datachannel.TURNConfig  // TURN 中继服务器，仅中继策略和浏览器使用
+	tcpMux      ice.TCPMux              // 固定端口的 ICE-TCP 监听，未配置时为 nil
+	resumable   map[string]*peerSession // 按恢复令牌索引的进行中会话
+	resumeGrace time.Duration           // 信令断开后等待重连的宽限期
+
+	negotiationTimeout time.Duration // 会话须在该时间内建立连接
+	idleTimeout        time.Duration // 没有信令和数据通道流量超过该时间即结束会话
+
+	draining atomic.Bool                       // 节点正在关闭，不再接受新会话
+	probes   map[probeChannel]*session.Session // 进行中的 WebSocket、QUIC 探测通道
 }
 
 var connManager = &ConnectionManager{
 	connections: make(map[string]*webrtc.PeerConnection),
+	sessions:    session.NewStore(session.Options{}),
+	resumable:   make(map[string]*peerSession),
+	resumeGrace: defaultResumeGrace,
+
+	negotiationTimeout: defaultNegotiationTimeout,
+	idleTimeout:        defaultIdleTimeout,
+
+	probes: make(map[probeChannel]*session.Session),
 }
 
 // SetPublicIP 设置公网IP
@@ -42,10 +72,39 @@ func SetUDPPortRange(min, max uint16) {
 	}
 }
 
+// SetSessionStore 设置会话记录存储
+func SetSessionStore(store *session.Store) {
+	connManager.sessions = store
+}
+
+// SetImpairment 设置回显路径默认的网络损伤
+func SetImpairment(cfg impair.Config) {
+	connManager.impairment = cfg
+}
+
+// SetSTUNServer 设置内置 STUN 服务器，客户端据此检测 NAT 行为
+func SetSTUNServer(server *nat.Server) {
+	connManager.stun = server
+}
+
+// SetTURNServer 设置 TURN 中继服务器，供仅中继策略的会话和浏览器使用
+func SetTURNServer(cfg datachannel.TURNConfig) {
+	connManager.turn = cfg
+}
+
+// SetICETCPMux 设置所有会话共用的 ICE-TCP 监听
+func SetICETCPMux(mux ice.TCPMux) {
+	connManager.tcpMux = mux
+}
+
+// maxSignalMessage 信令消息大小上限，超出的帧不会被读入内存
+const maxSignalMessage = 1024 * 1024
+
 // WebSocketHandler 处理 WebSocket 连接
 func WebSocketHandler(ws *websocket.Conn) {
 	// 移除固定超时，改为使用心跳机制
 	defer ws.Close()
+	ws.MaxPayloadBytes = maxSignalMessage
 
 	// 验证Origin
 	if !validateOrigin(ws.Request()) {
@@ -54,66 +113,365 @@ func WebSocketHandler(ws *websocket.Conn) {
 		return
 	}
 
-	// 为每个连接创建独立的PeerConnection
-	var peerConnection *webrtc.PeerConnection
-	var err error
-	
-	// 使用完整配置初始化PeerConnection
-	peerConnection, err = datachannel.InitializePeerConnectionWithConfig(
-		connManager.publicIP,
-		connManager.udpPortMin,
-		connManager.udpPortMax,
-	)
-	
+	// 断线重连：resume 参数为会话开始时下发的恢复令牌，宽限期内接管原有的 PeerConnection 和会话记录
+	if token := ws.Request().URL.Query().Get("resume"); token != "" {
+		ps := connManager.resumeSession(token, ws)
+		if ps == nil {
+			log.Printf("Unknown or expired resume token from %s", ws.Request().RemoteAddr)
+			sendJSON(datachannel.WebSocketSignaler{Conn: ws}, resumeMessage{Type: msgTypeResume})
+			return
+		}
+		ps.serve(ws)
+		return
+	}
+
+	// 节点关闭期间只允许已有会话重连
+	if connManager.draining.Load() {
+		log.Printf("Rejecting session from %s: server is shutting down", ws.Request().RemoteAddr)
+		ws.WriteClose(http.StatusServiceUnavailable)
+		return
+	}
+
+	// 双栈对比测试：network 参数限定本会话只使用 IPv4 或 IPv6 候选；
+	// transport 参数限定只走 UDP、TCP 或 TURN 中继，用于排查时对比
+	query := ws.Request().URL.Query()
+	network := query.Get("network")
+	networkTypes, err := datachannel.NetworkTypesFor(network)
+	if err != nil {
+		log.Printf("Invalid network from %s: %v", ws.Request().RemoteAddr, err)
+		ws.WriteClose(http.StatusBadRequest)
+		return
+	}
+	transport := query.Get("transport")
+	if err := datachannel.ValidateTransport(transport, connManager.turn); err != nil {
+		log.Printf("Invalid transport from %s: %v", ws.Request().RemoteAddr, err)
+		ws.WriteClose(http.StatusBadRequest)
+		return
+	}
+
+	// 启用内置 STUN 服务器时，服务端和浏览器都通过客户端访问本节点的地址使用它
+	stunURLs := connManager.stunURLs(ws.Request())
+
+	// 为每个连接创建独立的PeerConnection，使用完整配置初始化
+	peer, err := datachannel.NewPeer(datachannel.Options{
+		PublicIP:     connManager.publicIP,
+		UDPPortMin:   connManager.udpPortMin,
+		UDPPortMax:   connManager.udpPortMax,
+		STUNServers:  stunURLs.ICEServers(),
+		NetworkTypes: networkTypes,
+		Transport:    transport,
+		TURN:         connManager.turn,
+		TCPMux:       connManager.tcpMux,
+	})
 	if err != nil {
 		log.Printf("Failed to initialize peer connection: %v", err)
 		ws.WriteClose(http.StatusInternalServerError)
 		return
 	}
-	
-	// 生成连接ID
-	connID := generateConnectionID()
-	
-	// 注册连接
+	peerConnection := peer.PeerConnection
+
+	// 创建会话记录，会话ID即连接ID
+	sess := connManager.sessions.Create(session.TransportDataChannel)
+	connID := sess.ID
+	if network != "" {
+		sess.SetResult(resultNetwork, network)
+		log.Printf("Session %s restricted to %s candidates", connID, network)
+	}
+
+	// 注册连接，此后由 peerSession 负责清理
+	ps := newPeerSession(ws, peer, sess, stunURLs, transport)
 	connManager.registerConnection(connID, peerConnection)
-	defer connManager.unregisterConnection(connID)
+	connManager.registerSession(ps)
+
+	if err := sendJSON(ps, sessionMessage{Type: msgTypeSession, ID: connID, STUN: stunURLs, ResumeToken: ps.token}); err != nil {
+		log.Printf("Failed to send session ID for %s: %v", connID, err)
+		ps.close()
+		return
+	}
 
-	datachannel.HandleICECandidate(peerConnection, ws)
+	datachannel.HandleICECandidate(peerConnection, ps)
+	ps.watchICE()
+	ps.watchConnection()
 
 	peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
-		d.OnOpen(func() {
-			log.Println("Data channel opened for connection:", connID)
-		})
-		d.OnMessage(func(msg webrtc.DataChannelMessage) {
-			if err := d.SendText(string(msg.Data)); err != nil {
-				log.Printf("Failed to send message for connection %s: %v", connID, err)
-			}
-		})
+		datachannel.Echo(d, &connManager.echoStats, ps.link.Load(), connID)
 	})
 
 	// 消息处理循环 - 支持长时间连接
-	for {
-		// 每次读取前重置超时时间（5分钟）
-		ws.SetReadDeadline(time.Now().Add(5 * time.Minute))
-		
-		var msg string
-		if err := websocket.Message.Receive(ws, &msg); err != nil {
-			log.Printf("Can't receive from %s: %v", connID, err)
-			return
+	ps.serve(ws)
+}
+
+// handleChannels 按请求创建各可靠性配置的预协商通道并回传配置，客户端据此创建对应通道
+func handleChannels(peer *datachannel.Peer, msg string, sig datachannel.Signaler, connID string) error {
+	var req channelsMessage
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return fmt.Errorf("failed to unmarshal channels request: %w", err)
+	}
+
+	profiles, err := datachannel.ReliabilityProfiles(req.LifetimeMs)
+	if err != nil {
+		return reject(err)
+	}
+	channels, err := peer.OpenProfileChannels(profiles)
+	if err != nil {
+		return reject(err)
+	}
+	// 损伤在应用层丢弃消息，可靠通道无法重传，因此对比通道不模拟损伤
+	for _, d := range channels {
+		datachannel.Echo(d, &connManager.echoStats, nil, connID)
+	}
+	return sendJSON(sig, channelsMessage{Type: msgTypeChannels, Profiles: profiles})
+}
+
+// handleNAT 根据客户端收集的 srflx 候选判断 NAT 映射行为，保存到会话并回传
+func handleNAT(sess *session.Session, msg string, sig datachannel.Signaler, urls *nat.URLs) error {
+	if urls == nil {
+		return reject(fmt.Errorf("STUN server is not enabled"))
+	}
+	var req natMessage
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return fmt.Errorf("failed to unmarshal nat report: %w", err)
+	}
+	report := nat.Classify(*urls, req.Candidates)
+	log.Printf("NAT for %s: %s (mapping %s)", sess.ID, report.Type, report.Mapping)
+	sess.SetResult(msgTypeNAT, report)
+	return sendJSON(sig, natMessage{Type: msgTypeNAT, Report: &report})
+}
+
+// handleImpair 按客户端请求创建本会话的网络损伤，回复生效的配置
+func handleImpair(msg string, sig datachannel.Signaler) (*impair.Link, error) {
+	var req impairMessage
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal impair request: %w", err)
+	}
+	if err := req.Config.Validate(); err != nil {
+		return nil, reject(err)
+	}
+	l := impair.NewLink(req.Config)
+	if err := sendJSON(sig, impairMessage{Type: msgTypeImpair, Config: l.Config()}); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// handleSamples 记录客户端上报的样本，回传最新的测试结果、新关闭的时间序列桶和中断事件；
+// link 非 nil 时同时回传服务端实际注入的损伤作为对照
+func handleSamples(sess *session.Session, msg string, sig datachannel.Signaler, link *impair.Link) error {
+	var batch samplesMessage
+	if err := json.Unmarshal([]byte(msg), &batch); err != nil {
+		return fmt.Errorf("failed to unmarshal samples: %w", err)
+	}
+
+	if batch.Channel != "" {
+		// 通道名由客户端上报，仅接受可靠性对比测试的通道
+		if !datachannel.IsProfileName(batch.Channel) {
+			return reject(fmt.Errorf("unknown channel %q", batch.Channel))
 		}
-		
-		// 验证消息大小
-		if len(msg) > 1024*1024 { // 1MB限制
-			log.Printf("Message too large from %s", connID)
-			ws.WriteClose(http.StatusRequestEntityTooLarge)
-			return
+		if err := sess.AddChannelSamples(batch.Channel, batch.Samples, batch.Final); err != nil {
+			return reject(err)
+		}
+		reports := sess.ChannelReports(datachannel.ProfileUnreliable)
+		sess.SetResult(msgTypeChannels, reports)
+		return sendJSON(sig, channelMetricsMessage{Type: msgTypeChannelMetrics, Channels: reports})
+	}
+
+	update := sess.AddSamples(batch.Samples, batch.Final)
+	if link != nil {
+		report := link.Report()
+		sess.SetResult(msgTypeImpair, report)
+		if err := sendJSON(sig, impairStatsMessage{Type: msgTypeImpairStats, Report: report}); err != nil {
+			return err
+		}
+	}
+	if err := sendJSON(sig, metricsMessage{Type: msgTypeMetrics, Report: update.Report}); err != nil {
+		return err
+	}
+	if len(update.Buckets) > 0 {
+		if err := sendJSON(sig, timeSeriesMessage{Type: msgTypeTimeSeries, Buckets: update.Buckets}); err != nil {
+			return err
+		}
+	}
+	if len(update.Outages) > 0 {
+		return sendJSON(sig, outagesMessage{Type: msgTypeOutages, Outages: update.Outages})
+	}
+	return nil
+}
+
+// handleMedia 启动 RTP 媒体流测试，并每秒推送一次 RTCP/拦截器统计直到连接结束
+func handleMedia(peer *datachannel.Peer, sess *session.Session, msg string, sig datachannel.Signaler, done <-chan struct{}) error {
+	var req mediaMessage
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return fmt.Errorf("failed to unmarshal media request: %w", err)
+	}
+
+	mediaTest, err := peer.StartMedia(req.MediaOptions)
+	if err != nil {
+		return reject(err)
+	}
+	log.Printf("Media test started for %s: audio %d kbps, video %d kbps", sess.ID, req.AudioKbps, req.VideoKbps)
+
+	go func() {
+		defer mediaTest.Close()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				sess.SetResult(msgTypeMedia, mediaTest.Report())
+				return
+			case <-ticker.C:
+			}
+			report := mediaTest.Report()
+			sess.SetResult(msgTypeMedia, report)
+			// 信令断开期间推送失败不影响测试，客户端重连后继续接收
+			sendJSON(sig, mediaStatsMessage{Type: msgTypeMediaStats, Report: report})
+		}
+	}()
+	return nil
+}
+
+// handleBWE 启动带宽估计测试，每秒推送一次估计值，连接结束时将完整结果保存到会话
+func handleBWE(peer *datachannel.Peer, sess *session.Session, msg string, sig datachannel.Signaler, done <-chan struct{}) error {
+	var req bweMessage
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return fmt.Errorf("failed to unmarshal bwe request: %w", err)
+	}
+
+	bwe, err := peer.StartBWE(req.BWEOptions)
+	if err != nil {
+		return reject(err)
+	}
+	log.Printf("Bandwidth estimation started for %s: limit %d kbps", sess.ID, req.MaxKbps)
+
+	go func() {
+		defer bwe.Close()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				sess.SetResult(msgTypeBWE, bwe.Report(true))
+				return
+			case <-ticker.C:
+			}
+			point, ok := bwe.Sample()
+			if !ok {
+				continue
+			}
+			report := bwe.Report(false)
+			sess.SetResult(msgTypeBWE, report)
+			sendJSON(sig, bweStatsMessage{Type: msgTypeBWEStats, Point: point, Report: report})
 		}
-		
-		if err := datachannel.HandleSDP(peerConnection, msg, ws); err != nil {
-			log.Printf("Failed to handle SDP for %s: %v", connID, err)
+	}()
+	return nil
+}
+
+// handleThroughput 创建吞吐量测试通道并在后台依次测量下行和上行，结果推送给客户端并保存到会话
+func handleThroughput(peer *datachannel.Peer, sess *session.Session, msg string, sig datachannel.Signaler, done <-chan struct{}) error {
+	var req throughputMessage
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		return fmt.Errorf("failed to unmarshal throughput request: %w", err)
+	}
+
+	test, err := peer.StartThroughput(req.ThroughputOptions)
+	if err != nil {
+		return reject(err)
+	}
+	opts := datachannel.ThroughputOptions{Seconds: test.Seconds()}
+	setup := throughputMessage{
+		Type:              msgTypeThroughput,
+		Phase:             "setup",
+		BulkID:            datachannel.ThroughputBulkID,
+		PingID:            datachannel.ThroughputPingID,
+		ThroughputOptions: opts,
+	}
+	if err := sendJSON(sig, setup); err != nil {
+		return err
+	}
+
+	go func() {
+		defer test.Close()
+		result, err := test.Run(done, func(phase string) {
+			sendJSON(sig, throughputMessage{Type: msgTypeThroughput, Phase: phase, ThroughputOptions: opts})
+		})
+		if err != nil {
+			log.Printf("Throughput test for %s failed: %v", sess.ID, err)
 			return
 		}
+		log.Printf("Throughput test for %s: download %.2f Mbps, upload %.2f Mbps",
+			sess.ID, result.Download.GoodputMbps, result.Upload.GoodputMbps)
+		sess.SetResult(msgTypeThroughput, result)
+		sendJSON(sig, throughputResultMessage{Type: msgTypeThroughputDone, Result: result})
+	}()
+	return nil
+}
+
+// iceServer 浏览器 RTCIceServer 格式
+type iceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEServersHandler 返回浏览器应使用的 ICE 服务器：启用内置 STUN 服务器时为其地址，否则为公共 STUN 服务器；
+// 配置了 TURN 时附带中继服务器及其（临时）凭据
+func ICEServersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	urls := connManager.stunURLs(r).ICEServers()
+	if len(urls) == 0 {
+		urls = datachannel.PublicSTUNServers
+	}
+	servers := []iceServer{{URLs: urls}}
+	if connManager.turn.Enabled() {
+		turn := connManager.turn.ICEServer()
+		servers = append(servers, iceServer{URLs: turn.URLs, Username: turn.Username, Credential: turn.Credential.(string)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		ICEServers []iceServer `json:"iceServers"`
+	}{servers})
+}
+
+// EchoStatsHandler 返回服务端累计的 DataChannel 回显计数、当前连接数及其状态分布
+func EchoStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	states := connManager.peerStats()
+	connections := 0
+	for _, n := range states {
+		connections += n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		datachannel.EchoSnapshot
+		Connections int            `json:"connections"`
+		States      map[string]int `json:"states"` // 各连接状态的 PeerConnection 数量
+	}{connManager.echoStats.Snapshot(), connections, states})
+}
+
+// stunURLs 返回客户端访问内置 STUN 服务器的地址：配置了公网IP时使用公网IP，
+// 否则主机名与客户端访问本节点时相同（经反向代理访问时该主机名未必指向本节点）
+func (cm *ConnectionManager) stunURLs(r *http.Request) *nat.URLs {
+	if cm.stun == nil {
+		return nil
+	}
+	host := cm.publicIP
+	if host == "" {
+		host = r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
 	}
+	urls := cm.stun.URLs(host)
+	return &urls
 }
 
 // validateOrigin 验证请求来源
@@ -124,13 +482,6 @@ func validateOrigin(r *http.Request) bool {
 	return origin != "" || r.Method == "GET"
 }
 
-// generateConnectionID 生成连接ID
-func generateConnectionID() string {
-	return time.Now().Format("20060102150405") + "-" +
-		   string(rune(time.Now().UnixNano()%26+65)) +
-		   string(rune(time.Now().UnixNano()%26+65))
-}
-
 // registerConnection 注册连接
 func (cm *ConnectionManager) registerConnection(id string, pc *webrtc.PeerConnection) {
 	cm.mutex.Lock()